@@ -13,6 +13,7 @@ import (
 	"github.com/gophertest/build"
 	"github.com/hpidcock/gophertest/cache/hasher"
 	"github.com/hpidcock/gophertest/dag"
+	"github.com/hpidcock/gophertest/util"
 	"github.com/pkg/errors"
 )
 
@@ -30,6 +31,13 @@ type Builder struct {
 	Tools    build.Tools
 
 	WorkDir string
+	// AsmDefines are passed to the assembler, see util.AsmDefines.
+	AsmDefines []string
+	// Cgo is the C toolchain for cgo packages.
+	Cgo util.CgoConfig
+	// TrimPath rewrites the package directory to its TrimDir in the built
+	// objects, as go build -trimpath does.
+	TrimPath bool
 }
 
 type BuildInfo struct {
@@ -37,18 +45,31 @@ type BuildInfo struct {
 	CompilingRuntimeLibrary  bool
 	IsComplete               bool
 	HasASM                   bool
+	HasSyso                  bool
+	HasCgo                   bool
 
 	BuildID string
 
 	BuildDir         string
 	WorkDir          string
 	CompileSourceDir string
+	// TrimPath is the -trimpath rewrite for the compiler, assembler and cgo.
+	TrimPath string
 
 	ObjFile          string
 	ASMImportFile    string
 	SymABIsFile      string
 	ImportConfigFile string
 	IncludeDir       string
+	EmbedConfigFile  string
+
+	// ASMFiles are assembled by the go assembler, GasFiles by the C compiler.
+	ASMFiles []dag.SFile
+	GasFiles []dag.SFile
+
+	CgoDir      string
+	CgoGoFiles  []string
+	CgoObjFiles []string
 }
 
 func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
@@ -81,6 +102,12 @@ func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
 	if err != nil {
 		return err
 	}
+	bi.TrimPath = bi.BuildDir + "=>"
+	if b.TrimPath {
+		// Files linked into the work dir are named as if in the source dir.
+		bi.TrimPath = fmt.Sprintf("%s=>%s;%s;%s=>%s",
+			bi.WorkDir, node.TrimDir, bi.TrimPath, node.SourceDir, node.TrimDir)
+	}
 
 	bi.CompileSourceDir = node.SourceDir
 	hasSourceRewrite := false
@@ -100,6 +127,14 @@ func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
 			}
 		}
 	}
+	if !hasSourceRewrite {
+		for _, v := range node.SysoFiles {
+			if v.Dir != bi.CompileSourceDir {
+				hasSourceRewrite = true
+				break
+			}
+		}
+	}
 
 	if hasSourceRewrite {
 		for _, v := range node.GoFiles {
@@ -114,10 +149,20 @@ func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
 				return errors.WithStack(err)
 			}
 		}
+		for _, v := range node.SysoFiles {
+			err = os.Symlink(path.Join(v.Dir, v.Filename), path.Join(bi.WorkDir, v.Filename))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
 		bi.CompileSourceDir = bi.WorkDir
 	}
 
-	bi.HasASM = len(node.SFiles) > 0
+	bi.ASMFiles, bi.GasFiles = splitSFiles(node)
+	bi.HasASM = len(bi.ASMFiles) > 0
+	bi.HasSyso = len(node.SysoFiles) > 0
+	bi.HasCgo = len(node.CgoFiles) > 0
+	bi.CgoDir = path.Join(bi.WorkDir, "_cgo")
 	bi.IncludeDir = path.Join(bi.WorkDir, fmt.Sprintf("include_%s", node.Name))
 	err = os.MkdirAll(bi.IncludeDir, 0777)
 	if err != nil {
@@ -127,6 +172,7 @@ func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
 	bi.ASMImportFile = path.Join(bi.IncludeDir, "go_asm.h")
 	bi.SymABIsFile = path.Join(bi.WorkDir, fmt.Sprintf("%s_symabis", node.Name))
 	bi.ImportConfigFile = path.Join(bi.WorkDir, fmt.Sprintf("%s_importcfg", node.Name))
+	bi.EmbedConfigFile = path.Join(bi.WorkDir, fmt.Sprintf("%s_embedcfg", node.Name))
 
 	// GOROOT non-domain packages are considered std lib packages by gc.
 	bi.CompilingStandardLibrary = node.Goroot && !strings.Contains(strings.Split(node.ImportPath, "/")[0], ".")
@@ -140,7 +186,7 @@ func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
 			bi.CompilingRuntimeLibrary = true
 		}
 	}
-	bi.IsComplete = !bi.HasASM
+	bi.IsComplete = !bi.HasASM && !bi.HasSyso && !bi.HasCgo
 	if bi.CompilingStandardLibrary {
 		// From go/src/cmd/go/internal/work/gc.go
 		switch node.ImportPath {
@@ -156,6 +202,13 @@ func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
 		}
 	}
 
+	if bi.HasCgo {
+		err = b.cgo(ctx, node, bi)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	err = b.build(ctx, node, bi)
 	if err != nil {
 		return errors.WithStack(err)
@@ -168,6 +221,20 @@ func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
 		}
 	}
 
+	if bi.HasSyso {
+		err = b.sysoPack(ctx, node, bi)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if bi.HasCgo {
+		err = b.cgoPack(ctx, node, bi)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	out := &bytes.Buffer{}
 	_, err = b.Tools.BuildID(build.BuildIDArgs{
 		Context:          b.BuildCtx,
@@ -190,7 +257,7 @@ func (b *Builder) Visit(ctx context.Context, node *dag.Node) error {
 
 func (b *Builder) genSymABIs(ctx context.Context, node *dag.Node, bi *BuildInfo) error {
 	asmFiles := []string{}
-	for _, f := range node.SFiles {
+	for _, f := range bi.ASMFiles {
 		asmFiles = append(asmFiles, f.Filename)
 	}
 	err := ioutil.WriteFile(bi.ASMImportFile, []byte(""), 0666)
@@ -204,14 +271,11 @@ func (b *Builder) genSymABIs(ctx context.Context, node *dag.Node, bi *BuildInfo)
 		Files:            asmFiles,
 		Stdout:           out,
 		Stderr:           out,
-		TrimPath:         bi.BuildDir + "=>",
+		TrimPath:         bi.TrimPath,
 		IncludeDirs:      []string{bi.IncludeDir, bi.WorkDir, path.Join(b.BuildCtx.GOROOT, "pkg", "include")},
-		Defines: []string{
-			"GOOS_" + b.BuildCtx.GOOS,
-			"GOARCH_" + b.BuildCtx.GOARCH,
-		},
-		GenSymABIs: true,
-		OutputFile: bi.SymABIsFile,
+		Defines:          b.AsmDefines,
+		GenSymABIs:       true,
+		OutputFile:       bi.SymABIsFile,
 	}
 	err = b.Tools.Assemble(args)
 	if err != nil {
@@ -223,7 +287,7 @@ func (b *Builder) genSymABIs(ctx context.Context, node *dag.Node, bi *BuildInfo)
 
 func (b *Builder) asmBuild(ctx context.Context, node *dag.Node, bi *BuildInfo) error {
 	asmObjs := []string{}
-	for _, asmFile := range node.SFiles {
+	for _, asmFile := range bi.ASMFiles {
 		asmObj := path.Join(bi.WorkDir, strings.TrimSuffix(asmFile.Filename, ".s")+".o")
 		out := &bytes.Buffer{}
 		args := build.AssembleArgs{
@@ -232,13 +296,10 @@ func (b *Builder) asmBuild(ctx context.Context, node *dag.Node, bi *BuildInfo) e
 			Files:            []string{asmFile.Filename},
 			Stdout:           out,
 			Stderr:           out,
-			TrimPath:         bi.BuildDir + "=>",
+			TrimPath:         bi.TrimPath,
 			IncludeDirs:      []string{bi.IncludeDir, bi.WorkDir, path.Join(b.BuildCtx.GOROOT, "pkg", "include")},
-			Defines: []string{
-				"GOOS_" + b.BuildCtx.GOOS,
-				"GOARCH_" + b.BuildCtx.GOARCH,
-			},
-			OutputFile: asmObj,
+			Defines:          b.AsmDefines,
+			OutputFile:       asmObj,
 		}
 		err := b.Tools.Assemble(args)
 		if err != nil {
@@ -266,13 +327,46 @@ func (b *Builder) asmBuild(ctx context.Context, node *dag.Node, bi *BuildInfo) e
 	return nil
 }
 
+// sysoPack adds prebuilt system objects to the package archive.
+func (b *Builder) sysoPack(ctx context.Context, node *dag.Node, bi *BuildInfo) error {
+	sysoFiles := []string{}
+	for _, f := range node.SysoFiles {
+		sysoFiles = append(sysoFiles, f.Filename)
+	}
+	out := &bytes.Buffer{}
+	args := build.PackArgs{
+		Context:          b.BuildCtx,
+		WorkingDirectory: bi.CompileSourceDir,
+		Stdout:           out,
+		Stderr:           out,
+		Op:               build.Append,
+		ObjectFile:       bi.ObjFile,
+		Names:            sysoFiles,
+	}
+	err := b.Tools.Pack(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed packing syso: %v", out)
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 func (b *Builder) build(ctx context.Context, node *dag.Node, bi *BuildInfo) error {
 	err := b.writeImportConfig(ctx, node, bi)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	files := []string{}
+	// The tools have no field for free-form flags, they are placed before the
+	// files instead.
+	files := append([]string(nil), node.GcFlags...)
+	if len(node.EmbedPatterns) > 0 {
+		err = b.writeEmbedConfig(ctx, node, bi)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		files = append(files, "-embedcfg", bi.EmbedConfigFile)
+	}
 	for _, f := range node.GoFiles {
 		files = append(files, f.Filename)
 		if !node.Tests && f.Test {
@@ -292,6 +386,7 @@ func (b *Builder) build(ctx context.Context, node *dag.Node, bi *BuildInfo) erro
 			of.Close()
 		}
 	}
+	files = append(files, bi.CgoGoFiles...)
 
 	out := &bytes.Buffer{}
 	args := build.CompileArgs{
@@ -300,7 +395,7 @@ func (b *Builder) build(ctx context.Context, node *dag.Node, bi *BuildInfo) erro
 		Files:                    files,
 		Stdout:                   out,
 		Stderr:                   out,
-		TrimPath:                 bi.BuildDir + "=>",
+		TrimPath:                 bi.TrimPath,
 		Concurrency:              4,
 		PackageImportPath:        node.ImportPath,
 		ImportConfigFile:         bi.ImportConfigFile,
@@ -310,6 +405,7 @@ func (b *Builder) build(ctx context.Context, node *dag.Node, bi *BuildInfo) erro
 		Pack:                     true,
 		OutputFile:               bi.ObjFile,
 		BuildID:                  bi.BuildID + "/" + bi.BuildID,
+		Race:                     util.RaceEnabled(b.BuildCtx),
 	}
 	if bi.HasASM {
 		args.SymABIsFile = bi.SymABIsFile
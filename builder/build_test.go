@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	gobuild "go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/hpidcock/gophertest/dag"
+)
+
+// TestSysoPack adds a system object built by the C compiler to a compiled
+// package.
+func TestSysoPack(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	if _, err := os.Stat(path.Join(gobuild.ToolDir, "pack")); err != nil {
+		t.Skip("pack tool not found")
+	}
+	dir, err := ioutil.TempDir("", "gophertest-syso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcDir := path.Join(dir, "src")
+	err = os.MkdirAll(srcDir, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"x.go": "package x\n",
+		"x.c":  "int x(void) { return 1; }\n",
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(path.Join(srcDir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	objFile := path.Join(dir, "x.a")
+	for _, args := range [][]string{
+		{"gcc", "-c", "-o", path.Join(srcDir, "x.syso"), path.Join(srcDir, "x.c")},
+		{"go", "tool", "compile", "-p", "example.com/x", "-pack", "-o", objFile, path.Join(srcDir, "x.go")},
+	} {
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+
+	b := &Builder{
+		BuildCtx: gobuild.Default,
+		Tools:    build.DefaultTools,
+		WorkDir:  dir,
+	}
+	node := &dag.Node{
+		ImportPath: "example.com/x",
+		NodeBits: &dag.NodeBits{
+			Name:      "x",
+			SourceDir: srcDir,
+			SysoFiles: []dag.SysoFile{{Dir: srcDir, Filename: "x.syso"}},
+		},
+	}
+	bi := &BuildInfo{
+		CompileSourceDir: srcDir,
+		ObjFile:          objFile,
+	}
+	err = b.sysoPack(context.Background(), node, bi)
+	if err != nil {
+		t.Fatalf("sysoPack: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	err = b.Tools.Pack(build.PackArgs{
+		WorkingDirectory: dir,
+		Stdout:           out,
+		Stderr:           out,
+		Op:               build.List,
+		ObjectFile:       objFile,
+	})
+	if err != nil {
+		t.Fatalf("listing package archive: %v\n%s", err, out)
+	}
+	if !strings.Contains(out.String(), "x.syso\n") {
+		t.Errorf("package archive contains\n%s\nwant x.syso", out)
+	}
+}
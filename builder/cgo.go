@@ -0,0 +1,221 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	gobuild "go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gophertest/build"
+	"github.com/hpidcock/gophertest/dag"
+	"github.com/hpidcock/gophertest/util"
+	"github.com/pkg/errors"
+)
+
+// splitSFiles returns the assembly files for the go assembler and those for
+// the C compiler. In cgo packages the C compiler assembles them all, except
+// in runtime/cgo which has both.
+func splitSFiles(node *dag.Node) ([]dag.SFile, []dag.SFile) {
+	if len(node.CgoFiles) == 0 {
+		return node.SFiles, nil
+	}
+	if !node.Standard || node.ImportPath != "runtime/cgo" {
+		return nil, node.SFiles
+	}
+	var asmFiles, gasFiles []dag.SFile
+	for _, f := range node.SFiles {
+		if strings.HasPrefix(f.Filename, "gcc_") {
+			gasFiles = append(gasFiles, f)
+		} else {
+			asmFiles = append(asmFiles, f)
+		}
+	}
+	return asmFiles, gasFiles
+}
+
+// cgo translates the cgo files, compiles the C files with the C compiler and
+// records the generated Go files to compile and the objects to pack, as the
+// go command does.
+func (b *Builder) cgo(ctx context.Context, node *dag.Node, bi *BuildInfo) error {
+	err := os.MkdirAll(bi.CgoDir, 0777)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	cppflags := append(append([]string(nil), b.Cgo.CPPFLAGS...), node.CgoCPPFLAGS...)
+	cppflags = append(cppflags, "-I", bi.CgoDir)
+	cflags := append(append([]string(nil), b.Cgo.CFLAGS...), node.CgoCFLAGS...)
+	ldflags := append(append([]string(nil), b.Cgo.LDFLAGS...), node.CgoLDFLAGS...)
+
+	env := append(util.BuildEnv(b.BuildCtx), "CC="+strings.Join(b.Cgo.CC, " "))
+	args := []string{"-objdir", bi.CgoDir, "-importpath", node.ImportPath, "-trimpath", bi.TrimPath}
+	if node.Standard {
+		switch node.ImportPath {
+		case "runtime/cgo":
+			args = append(args, "-import_runtime_cgo=false", "-import_syscall=false")
+		case "runtime/race", "runtime/msan", "runtime/asan":
+			args = append(args, "-import_syscall=false")
+		}
+	}
+	if len(ldflags) > 0 {
+		// The flags are recorded in the generated code for the linker.
+		quoted := []string{}
+		for _, flag := range ldflags {
+			quoted = append(quoted, strconv.Quote(flag))
+		}
+		args = append(args, "-ldflags="+strings.Join(quoted, " "))
+		env = append(env, "CGO_LDFLAGS=")
+	}
+	args = append(args, "--")
+	args = append(args, cppflags...)
+	args = append(args, cflags...)
+
+	goFiles := []string{path.Join(bi.CgoDir, "_cgo_gotypes.go")}
+	cFiles := []string{path.Join(bi.CgoDir, "_cgo_export.c")}
+	for _, f := range node.CgoFiles {
+		args = append(args, path.Join(f.Dir, f.Filename))
+		name := strings.TrimSuffix(f.Filename, ".go")
+		goFiles = append(goFiles, path.Join(bi.CgoDir, name+".cgo1.go"))
+		cFiles = append(cFiles, path.Join(bi.CgoDir, name+".cgo2.c"))
+	}
+	cgoTool := path.Join(gobuild.ToolDir, "cgo")
+	err = b.run(node.SourceDir, env, cgoTool, args...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, f := range bi.GasFiles {
+		cFiles = append(cFiles, path.Join(f.Dir, f.Filename))
+	}
+	for _, f := range node.CFiles {
+		cFiles = append(cFiles, path.Join(f.Dir, f.Filename))
+	}
+	ccArgs := append(b.ccArgs(node), cppflags...)
+	ccArgs = append(ccArgs, cflags...)
+	if b.TrimPath {
+		ccArgs = append(ccArgs,
+			"-fdebug-prefix-map="+bi.BuildDir+"=/tmp/go-build",
+			"-fdebug-prefix-map="+node.SourceDir+"="+node.TrimDir,
+			"-gno-record-gcc-switches")
+	}
+	objs := []string{}
+	for i, cFile := range cFiles {
+		obj := path.Join(bi.CgoDir, fmt.Sprintf("_x%03d.o", i+1))
+		err = b.run(node.SourceDir, env, b.Cgo.CC[0], append(ccArgs, "-o", obj, "-c", cFile)...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		objs = append(objs, obj)
+	}
+
+	// Linking a stub main finds the symbols the package imports dynamically,
+	// which the go linker needs to link internally.
+	mainObj := path.Join(bi.CgoDir, "_cgo_main.o")
+	err = b.run(node.SourceDir, env, b.Cgo.CC[0], append(ccArgs, "-o", mainObj, "-c", path.Join(bi.CgoDir, "_cgo_main.c"))...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dynObj := path.Join(bi.CgoDir, "_cgo_.o")
+	linkArgs := append(b.ccArgs(node), "-o", dynObj, mainObj)
+	linkArgs = append(linkArgs, objs...)
+	for _, f := range node.SysoFiles {
+		linkArgs = append(linkArgs, path.Join(f.Dir, f.Filename))
+	}
+	linkArgs = append(linkArgs, ldflags...)
+	err = b.run(node.SourceDir, env, b.Cgo.CC[0], linkArgs...)
+	if err != nil {
+		// The go linker looks for dynimportfail and links externally.
+		fail := path.Join(bi.CgoDir, "dynimportfail")
+		err = ioutil.WriteFile(fail, nil, 0666)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		objs = append(objs, fail)
+	} else {
+		importGo := path.Join(bi.CgoDir, "_cgo_import.go")
+		args := []string{"-dynpackage", node.Name, "-dynimport", dynObj, "-dynout", importGo}
+		if node.Standard && node.ImportPath == "runtime/cgo" {
+			args = append(args, "-dynlinker")
+		}
+		err = b.run(node.SourceDir, env, cgoTool, args...)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		goFiles = append(goFiles, importGo)
+	}
+
+	bi.CgoGoFiles = goFiles
+	bi.CgoObjFiles = objs
+	return nil
+}
+
+// ccArgs are the C compiler and the flags the go command always passes it.
+func (b *Builder) ccArgs(node *dag.Node) []string {
+	args := append([]string(nil), b.Cgo.CC[1:]...)
+	args = append(args, "-I", node.SourceDir)
+	if b.BuildCtx.GOOS != "windows" {
+		args = append(args, "-fPIC")
+	}
+	switch b.BuildCtx.GOARCH {
+	case "386":
+		args = append(args, "-m32")
+	case "amd64":
+		if b.BuildCtx.GOOS == "darwin" {
+			args = append(args, "-arch", "x86_64")
+		}
+		args = append(args, "-m64")
+	case "arm64":
+		if b.BuildCtx.GOOS == "darwin" {
+			args = append(args, "-arch", "arm64")
+		}
+	case "arm":
+		args = append(args, "-marm")
+	}
+	return append(args, "-pthread", "-fmessage-length=0")
+}
+
+// run a C compiler or cgo command.
+func (b *Builder) run(dir string, env []string, name string, args ...string) error {
+	if build.DebugLog {
+		fmt.Printf("cd %s\n", dir)
+		fmt.Printf("%s %s\n", name, strings.Join(args, " "))
+	}
+	out := &bytes.Buffer{}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err := cmd.Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed running %s: %v", path.Base(name), out)
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// cgoPack adds the objects built from C to the package archive.
+func (b *Builder) cgoPack(ctx context.Context, node *dag.Node, bi *BuildInfo) error {
+	out := &bytes.Buffer{}
+	args := build.PackArgs{
+		Context:          b.BuildCtx,
+		WorkingDirectory: bi.CompileSourceDir,
+		Stdout:           out,
+		Stderr:           out,
+		Op:               build.Append,
+		ObjectFile:       bi.ObjFile,
+		Names:            bi.CgoObjFiles,
+	}
+	err := b.Tools.Pack(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed packing cgo: %v", out)
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	gobuild "go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/hpidcock/gophertest/dag"
+	"github.com/hpidcock/gophertest/util"
+)
+
+func TestSplitSFiles(t *testing.T) {
+	sFiles := func(names ...string) []dag.SFile {
+		files := []dag.SFile(nil)
+		for _, name := range names {
+			files = append(files, dag.SFile{Dir: "/src", Filename: name})
+		}
+		return files
+	}
+	tests := []struct {
+		importPath string
+		standard   bool
+		cgo        bool
+		sFiles     []string
+		wantASM    []dag.SFile
+		wantGas    []dag.SFile
+	}{
+		{"github.com/x/y", false, false, []string{"a_amd64.s"}, sFiles("a_amd64.s"), nil},
+		{"github.com/x/y", false, true, []string{"a_amd64.s"}, nil, sFiles("a_amd64.s")},
+		{"runtime/cgo", true, true, []string{"asm_amd64.s", "gcc_amd64.S"}, sFiles("asm_amd64.s"), sFiles("gcc_amd64.S")},
+		{"runtime/cgo", false, true, []string{"asm_amd64.s", "gcc_amd64.S"}, nil, sFiles("asm_amd64.s", "gcc_amd64.S")},
+	}
+	for _, test := range tests {
+		node := &dag.Node{
+			ImportPath: test.importPath,
+			NodeBits: &dag.NodeBits{
+				Standard: test.standard,
+				SFiles:   sFiles(test.sFiles...),
+			},
+		}
+		if test.cgo {
+			node.CgoFiles = []dag.GoFile{{Dir: "/src", Filename: "a.go"}}
+		}
+		asm, gas := splitSFiles(node)
+		if !reflect.DeepEqual(asm, test.wantASM) || !reflect.DeepEqual(gas, test.wantGas) {
+			t.Errorf("splitSFiles(%q, cgo %t) = %v, %v, want %v, %v", test.importPath, test.cgo, asm, gas, test.wantASM, test.wantGas)
+		}
+	}
+}
+
+func TestCCArgs(t *testing.T) {
+	tests := []struct {
+		goos   string
+		goarch string
+		want   []string
+	}{
+		{"linux", "amd64", []string{"-g", "-I", "/src", "-fPIC", "-m64", "-pthread", "-fmessage-length=0"}},
+		{"darwin", "arm64", []string{"-g", "-I", "/src", "-fPIC", "-arch", "arm64", "-pthread", "-fmessage-length=0"}},
+		{"windows", "386", []string{"-g", "-I", "/src", "-m32", "-pthread", "-fmessage-length=0"}},
+	}
+	for _, test := range tests {
+		b := &Builder{Cgo: util.CgoConfig{CC: []string{"gcc", "-g"}}}
+		b.BuildCtx.GOOS = test.goos
+		b.BuildCtx.GOARCH = test.goarch
+		node := &dag.Node{NodeBits: &dag.NodeBits{SourceDir: "/src"}}
+		got := b.ccArgs(node)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ccArgs for %s/%s = %q, want %q", test.goos, test.goarch, got, test.want)
+		}
+	}
+}
+
+// TestCgo builds a cgo package with the cgo tool and the C compiler, packs the
+// C objects into the compiled package and links a program using it.
+func TestCgo(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	if _, err := os.Stat(path.Join(gobuild.ToolDir, "pack")); err != nil {
+		t.Skip("pack tool not found")
+	}
+	dir, err := ioutil.TempDir("", "gophertest-cgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcDir := path.Join(dir, "src")
+	files := map[string]string{
+		"add.go": `package add
+
+// #include "add.h"
+import "C"
+
+func Add(a, b int) int {
+	return int(C.add(C.int(a), C.int(b)))
+}
+`,
+		"add.h": "int add(int a, int b);\n",
+		"add.c": "#include \"add.h\"\n\nint add(int a, int b) { return a + b; }\n",
+		"main.go": `package main
+
+import "example.com/add"
+
+func main() {
+	println(add.Add(1, 2))
+}
+`,
+	}
+	err = os.MkdirAll(srcDir, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(path.Join(srcDir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buildCtx := gobuild.Default
+	buildCtx.CgoEnabled = true
+	b := &Builder{
+		BuildCtx: buildCtx,
+		Tools:    build.DefaultTools,
+		WorkDir:  dir,
+		Cgo:      util.CgoConfig{CC: []string{"gcc"}},
+	}
+	node := &dag.Node{
+		ImportPath: "example.com/add",
+		NodeBits: &dag.NodeBits{
+			Name:      "add",
+			SourceDir: srcDir,
+			CgoFiles:  []dag.GoFile{{Dir: srcDir, Filename: "add.go"}},
+			CFiles:    []dag.CFile{{Dir: srcDir, Filename: "add.c"}},
+			HFiles:    []dag.HFile{{Dir: srcDir, Filename: "add.h"}},
+		},
+	}
+	bi := &BuildInfo{
+		CompileSourceDir: srcDir,
+		CgoDir:           path.Join(dir, "_cgo"),
+		ObjFile:          path.Join(dir, "add.a"),
+	}
+	err = b.cgo(context.Background(), node, bi)
+	if err != nil {
+		t.Fatalf("cgo: %v", err)
+	}
+	wantGoFiles := []string{"_cgo_gotypes.go", "add.cgo1.go", "_cgo_import.go"}
+	gotGoFiles := []string(nil)
+	for _, f := range bi.CgoGoFiles {
+		gotGoFiles = append(gotGoFiles, path.Base(f))
+	}
+	if !reflect.DeepEqual(gotGoFiles, wantGoFiles) {
+		t.Errorf("generated Go files are %q, want %q", gotGoFiles, wantGoFiles)
+	}
+	// _cgo_export.c, add.cgo2.c and add.c.
+	if len(bi.CgoObjFiles) != 3 {
+		t.Errorf("got C objects %q, want 3", bi.CgoObjFiles)
+	}
+
+	importConfig := path.Join(dir, "importcfg")
+	out := runGo(t, srcDir, "list", "-export", "-deps", "-f", "{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}", "runtime/cgo", "syscall")
+	out += "packagefile example.com/add=" + bi.ObjFile + "\n"
+	err = ioutil.WriteFile(importConfig, []byte(out), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := func(args ...string) {
+		cmd := exec.Command("go", append([]string{"tool"}, args...)...)
+		cmd.Dir = srcDir
+		cmd.Env = append(os.Environ(), "CGO_ENABLED=1")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("go tool %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+	tool(append([]string{"compile", "-p", "example.com/add", "-importcfg", importConfig, "-pack", "-o", bi.ObjFile}, bi.CgoGoFiles...)...)
+	err = b.cgoPack(context.Background(), node, bi)
+	if err != nil {
+		t.Fatalf("cgoPack: %v", err)
+	}
+	tool("compile", "-p", "main", "-importcfg", importConfig, "-pack", "-o", path.Join(dir, "main.a"), path.Join(srcDir, "main.go"))
+	tool("link", "-importcfg", importConfig, "-extld", "gcc", "-o", path.Join(dir, "add"), path.Join(dir, "main.a"))
+
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(path.Join(dir, "add"))
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("running: %v\n%s", err, stderr)
+	}
+	if got := strings.TrimSpace(stderr.String()); got != "3" {
+		t.Errorf("program printed %q, want %q", got, "3")
+	}
+}
+
+func runGo(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=1", "GOFLAGS=", "GO111MODULE=off")
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return string(out)
+}
@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/hpidcock/gophertest/dag"
+	"github.com/pkg/errors"
+)
+
+// embedConfig is the file passed to the compiler with -embedcfg.
+type embedConfig struct {
+	// Patterns maps each //go:embed pattern to the files it matches.
+	Patterns map[string][]string
+	// Files maps each embedded file to where it is on disk.
+	Files map[string]string
+}
+
+// writeEmbedConfig writes the embed config for the package to bi.EmbedConfigFile.
+func (b *Builder) writeEmbedConfig(ctx context.Context, node *dag.Node, bi *BuildInfo) error {
+	cfg := &embedConfig{
+		Patterns: map[string][]string{},
+		Files:    map[string]string{},
+	}
+	for _, pattern := range node.EmbedPatterns {
+		matched := []string{}
+		for _, f := range node.EmbedFiles {
+			if embedMatch(pattern, f.Filename) {
+				matched = append(matched, f.Filename)
+			}
+		}
+		if len(matched) == 0 {
+			return fmt.Errorf("pattern %s: no matching files found", pattern)
+		}
+		cfg.Patterns[pattern] = matched
+	}
+	for _, f := range node.EmbedFiles {
+		cfg.Files[f.Filename] = path.Join(f.Dir, f.Filename)
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = ioutil.WriteFile(bi.EmbedConfigFile, data, 0666)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// embedMatch reports if a //go:embed pattern includes filename, either by
+// naming it or naming a directory containing it. Files in a directory are
+// skipped if they start with . or _ unless the pattern starts with all:.
+func embedMatch(pattern string, filename string) bool {
+	glob := strings.TrimPrefix(pattern, "all:")
+	all := glob != pattern
+	if ok, _ := path.Match(glob, filename); ok {
+		return true
+	}
+	parts := strings.Split(filename, "/")
+	for i := len(parts) - 1; i > 0; i-- {
+		if !all && (strings.HasPrefix(parts[i], ".") || strings.HasPrefix(parts[i], "_")) {
+			return false
+		}
+		dir := strings.Join(parts[:i], "/")
+		if ok, _ := path.Match(glob, dir); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package builder
+
+import (
+	"testing"
+)
+
+func TestEmbedMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		filename string
+		want     bool
+	}{
+		{"a.txt", "a.txt", true},
+		{"a.txt", "b.txt", false},
+		{"*.txt", "a.txt", true},
+		{"*.txt", "d/a.txt", false},
+		{"d", "d/a.txt", true},
+		{"d", "d/e/a.txt", true},
+		{"d", "dx/a.txt", false},
+		{"d/*", "d/e/a.txt", true},
+		{".hidden", ".hidden", true},
+		{"d", "d/.hidden", false},
+		{"d", "d/_skip.txt", false},
+		{"d", "d/.git/config", false},
+		{"d", "d/_e/a.txt", false},
+		{"all:d", "d/.hidden", true},
+		{"all:d", "d/_e/a.txt", true},
+		{"all:a.txt", "a.txt", true},
+	}
+	for _, test := range tests {
+		got := embedMatch(test.pattern, test.filename)
+		if got != test.want {
+			t.Errorf("embedMatch(%q, %q) = %t, want %t", test.pattern, test.filename, got, test.want)
+		}
+	}
+}
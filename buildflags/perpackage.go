@@ -0,0 +1,102 @@
+package buildflags
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpidcock/gophertest/util"
+)
+
+// PerPackage holds repeated -gcflags or -ldflags values. As with the go
+// command each value is "[pattern=]flags", flags without a pattern apply to
+// the test packages and later values override earlier ones.
+type PerPackage struct {
+	// Dir resolves relative patterns such as ./pkg/...
+	Dir string
+
+	values []value
+}
+
+type value struct {
+	raw     string
+	pattern string
+	flags   []string
+}
+
+// Package describes a package to match against patterns.
+type Package struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	// Test is set for the packages being tested.
+	Test bool
+}
+
+func (p *PerPackage) String() string {
+	raw := []string{}
+	for _, v := range p.values {
+		raw = append(raw, v.raw)
+	}
+	return strings.Join(raw, " ")
+}
+
+func (p *PerPackage) Set(s string) error {
+	v := value{raw: s}
+	pattern := ""
+	// A pattern must come before any flags.
+	if s != "" && s[0] != '-' {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return fmt.Errorf("missing =<value> in <pattern>=<value>")
+		}
+		pattern, s = s[:i], s[i+1:]
+		if pattern == "" {
+			return fmt.Errorf("missing <pattern> in <pattern>=<value>")
+		}
+	}
+	flags, err := util.SplitQuotedFields(s)
+	if err != nil {
+		return err
+	}
+	v.pattern = pattern
+	v.flags = flags
+	p.values = append(p.values, v)
+	return nil
+}
+
+// For returns the flags for pkg.
+func (p *PerPackage) For(pkg Package) []string {
+	var flags []string
+	for _, v := range p.values {
+		if p.match(v.pattern, pkg) {
+			flags = v.flags
+		}
+	}
+	return flags
+}
+
+func (p *PerPackage) match(pattern string, pkg Package) bool {
+	if pattern == "" {
+		return pkg.Test
+	}
+	return MatchPattern(p.Dir, pattern)(pkg)
+}
+
+// MatchPattern returns a function reporting if a package matches the pattern
+// as the go command would. The pattern may be "all", "std", an import path
+// pattern or a directory pattern such as ./pkg/... relative to dir.
+func MatchPattern(dir string, pattern string) func(pkg Package) bool {
+	switch {
+	case pattern == "all":
+		return func(pkg Package) bool { return true }
+	case pattern == "std":
+		return func(pkg Package) bool { return pkg.Standard }
+	case pattern == "." || pattern == ".." || strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../"):
+		match := util.MatchPattern(filepath.ToSlash(filepath.Join(dir, pattern)))
+		return func(pkg Package) bool { return match(filepath.ToSlash(pkg.Dir)) }
+	default:
+		match := util.MatchPattern(pattern)
+		return func(pkg Package) bool { return match(pkg.ImportPath) }
+	}
+}
@@ -0,0 +1,66 @@
+package buildflags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPerPackage(t *testing.T) {
+	test := Package{ImportPath: "github.com/x/y", Dir: "/src/y", Test: true}
+	dep := Package{ImportPath: "github.com/x/y/z", Dir: "/src/y/z"}
+	other := Package{ImportPath: "github.com/w", Dir: "/src/w"}
+	std := Package{ImportPath: "fmt", Dir: "/goroot/src/fmt", Standard: true}
+
+	tests := []struct {
+		name   string
+		values []string
+		pkg    Package
+		want   []string
+	}{
+		{"no values", nil, test, nil},
+		{"test packages", []string{"-N -l"}, test, []string{"-N", "-l"}},
+		{"not a test package", []string{"-N -l"}, dep, nil},
+		{"all", []string{"all=-N"}, std, []string{"-N"}},
+		{"std", []string{"std=-N"}, std, []string{"-N"}},
+		{"std excludes others", []string{"std=-N"}, other, nil},
+		{"import path", []string{"github.com/x/y/z=-N"}, dep, []string{"-N"}},
+		{"wildcard", []string{"github.com/x/...=-N"}, dep, []string{"-N"}},
+		{"wildcard parent", []string{"github.com/x/y/...=-N"}, test, []string{"-N"}},
+		{"wildcard excludes", []string{"github.com/x/y/...=-N"}, other, nil},
+		{"relative", []string{"./z=-N"}, dep, []string{"-N"}},
+		{"relative wildcard", []string{"./...=-N"}, dep, []string{"-N"}},
+		{"relative parent", []string{"../w=-N"}, other, []string{"-N"}},
+		{"last match wins", []string{"all=-N", "github.com/x/y/z=-l"}, dep, []string{"-l"}},
+		{"later non match ignored", []string{"all=-N", "std=-l"}, dep, []string{"-N"}},
+		{"empty clears", []string{"all=-N", "all="}, dep, nil},
+		{"quoted", []string{`-X 'main.v=a b'`}, test, []string{"-X", "main.v=a b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PerPackage{Dir: "/src/y"}
+			for _, v := range tt.values {
+				err := p.Set(v)
+				if err != nil {
+					t.Fatalf("Set(%q): %v", v, err)
+				}
+			}
+			got := p.For(tt.pkg)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("For(%q) = %q, want %q", tt.pkg.ImportPath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPerPackageSetErrors(t *testing.T) {
+	for _, value := range []string{
+		"github.com/x/y",
+		"=-N",
+		`-X "main.v=a`,
+	} {
+		p := &PerPackage{}
+		if err := p.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", value)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+)
+
+// Key identifies a built package in a Cache.
+type Key struct {
+	ImportPath string
+	// Name is the package name, which differs from the import path for
+	// external test packages.
+	Name    string
+	BuildID string
+}
+
+// Entry is a built package: the compiled object and the rewritten source
+// files it was built from, which the test main generator and linker read in
+// place of the originals.
+type Entry struct {
+	// Object is the path of the compiled package archive.
+	Object string
+	// Sources are the paths of the rewritten .go, .s and .syso files. Their
+	// file names are those in the package.
+	Sources []string
+}
+
+// Cache stores built packages by build ID, so packages whose source and
+// build settings are unchanged are not compiled again.
+type Cache interface {
+	// Get returns the package built with key.BuildID, or nil if it is not
+	// cached. The returned files may be read until the build finishes.
+	Get(ctx context.Context, key Key) (*Entry, error)
+	// Put stores a built package, the files in entry are copied.
+	Put(ctx context.Context, key Key, entry *Entry) error
+}
@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hpidcock/gophertest/util"
+)
+
+type Logger interface {
+	Infof(format string, args ...interface{})
+}
+
+// buildsDir holds the entries of a Dir, under a directory named by the first
+// two characters of their build ID.
+const buildsDir = "builds"
+
+// Dir is a Cache in a local directory. Each build is stored under its build
+// ID, with a manifest listing the object and rewritten sources, so any number
+// of versions of a package are kept.
+type Dir struct {
+	Root string
+}
+
+var _ Cache = (*Dir)(nil)
+var _ Trimmer = (*Dir)(nil)
+
+func (d *Dir) Get(ctx context.Context, key Key) (*Entry, error) {
+	if !buildIDPattern.MatchString(key.BuildID) {
+		return nil, fmt.Errorf("invalid build id %q", key.BuildID)
+	}
+
+	manifestFilepath := path.Join(d.entryDir(key.BuildID), "manifest")
+	files, err := readManifest(manifestFilepath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("empty manifest %q", manifestFilepath)
+	}
+
+	entry := &Entry{
+		Object: files[0],
+	}
+	for _, filename := range files[1:] {
+		if isSource(filename) {
+			entry.Sources = append(entry.Sources, filename)
+		}
+	}
+	err = markUsed(manifestFilepath, time.Now())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return entry, nil
+}
+
+func (d *Dir) Put(ctx context.Context, key Key, entry *Entry) error {
+	if !buildIDPattern.MatchString(key.BuildID) {
+		return fmt.Errorf("invalid build id %q", key.BuildID)
+	}
+	entryDir := d.entryDir(key.BuildID)
+	if _, err := os.Stat(entryDir); err == nil {
+		// The same build is already stored.
+		return nil
+	}
+	err := os.MkdirAll(path.Dir(entryDir), 0777)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// The entry is written to a temporary directory and renamed into place,
+	// so an entry is always complete.
+	tmpDir, err := ioutil.TempDir(path.Dir(entryDir), ".tmp-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	manifest := &bytes.Buffer{}
+	objFilename := fmt.Sprintf("%s.obj", key.Name)
+	fmt.Fprintln(manifest, objFilename)
+	err = util.FileCopy(entry.Object, path.Join(tmpDir, objFilename))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, source := range entry.Sources {
+		filename := path.Base(source)
+		fmt.Fprintln(manifest, filename)
+		err = util.FileCopy(source, path.Join(tmpDir, filename))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	err = ioutil.WriteFile(path.Join(tmpDir, "manifest"), manifest.Bytes(), 0666)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.Rename(tmpDir, entryDir)
+	if err != nil {
+		if _, statErr := os.Stat(entryDir); statErr == nil {
+			// Stored by another build at the same time.
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Usage lists each build by its build ID. Packages stored by import path,
+// before builds were stored by build ID, are listed by their manifest as
+// never used, so they are trimmed first.
+func (d *Dir) Usage() ([]Usage, error) {
+	usage := []Usage{}
+	err := filepath.Walk(d.Root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(d.Root, filename)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		u := Usage{}
+		switch {
+		case strings.HasPrefix(rel, buildsDir+"/") && path.Base(rel) == "manifest":
+			u.ID = path.Base(path.Dir(rel))
+			u.Used = info.ModTime()
+		case strings.HasSuffix(rel, ".manifest"):
+			u.ID = rel
+		default:
+			return nil
+		}
+		files, err := readManifest(filename)
+		if err != nil {
+			return err
+		}
+		size, err := filesSize(files)
+		if err != nil {
+			return err
+		}
+		u.Size = info.Size() + size
+		usage = append(usage, u)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return usage, nil
+}
+
+// Remove a build, or a package stored by import path.
+func (d *Dir) Remove(id string) error {
+	if buildIDPattern.MatchString(id) {
+		err := os.RemoveAll(d.entryDir(id))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	}
+
+	manifestFilepath := path.Join(d.Root, id)
+	files, err := readManifest(manifestFilepath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, filename := range append(files, manifestFilepath) {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+	// Remove the import path directories once they are empty.
+	for dir := path.Dir(manifestFilepath); dir != d.Root && strings.HasPrefix(dir, d.Root); dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// entryDir is where the build with buildID is stored.
+func (d *Dir) entryDir(buildID string) string {
+	prefix := buildID
+	if len(prefix) > 2 {
+		prefix = prefix[:2]
+	}
+	return path.Join(d.Root, buildsDir, prefix, buildID)
+}
+
+// readManifest returns the paths of the files listed in a manifest.
+func readManifest(manifestFilepath string) ([]string, error) {
+	manifestBytes, err := ioutil.ReadFile(manifestFilepath)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, f := range strings.Split(string(manifestBytes), "\n") {
+		if f == "" {
+			continue
+		}
+		files = append(files, path.Join(path.Dir(manifestFilepath), f))
+	}
+	return files, nil
+}
+
+// isSource reports if a file in a manifest is a rewritten source file.
+func isSource(filename string) bool {
+	for _, ext := range []string{".go", ".s", ".syso"} {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
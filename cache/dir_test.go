@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeEntry writes a built package to dir, with content in each file.
+func writeEntry(t *testing.T, dir string, content string) *Entry {
+	entry := &Entry{
+		Object:  path.Join(dir, "x.obj"),
+		Sources: []string{path.Join(dir, "x.go"), path.Join(dir, "x_amd64.s")},
+	}
+	for _, filename := range append([]string{entry.Object}, entry.Sources...) {
+		writeFile(t, filename, content)
+	}
+	return entry
+}
+
+// checkEntry checks that got holds the files of want, each with content.
+func checkEntry(t *testing.T, got *Entry, want *Entry, content string) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("missing build")
+	}
+	if c := readFile(t, got.Object); c != content {
+		t.Errorf("object is %q, want %q", c, content)
+	}
+	if len(got.Sources) != len(want.Sources) {
+		t.Fatalf("got sources %q, want %q", got.Sources, want.Sources)
+	}
+	for i, source := range got.Sources {
+		if path.Base(source) != path.Base(want.Sources[i]) {
+			t.Errorf("source %d is %q, want %q", i, path.Base(source), path.Base(want.Sources[i]))
+		}
+		if c := readFile(t, source); c != content {
+			t.Errorf("source %s is %q, want %q", path.Base(source), c, content)
+		}
+	}
+}
+
+func TestDirRoundTrip(t *testing.T) {
+	root := tempDir(t, "")
+	defer os.RemoveAll(root)
+	var c Cache = &Dir{Root: tempDir(t, root)}
+	key := Key{ImportPath: "github.com/x", Name: "x", BuildID: "build-1"}
+
+	got, err := c.Get(context.Background(), key)
+	if got != nil || err != nil {
+		t.Fatalf("Get before Put = %v, %v, want a miss", got, err)
+	}
+
+	srcDir := tempDir(t, root)
+	entry := writeEntry(t, srcDir, "built")
+	err = c.Put(context.Background(), key, entry)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// The files are copied, so the build directory can be removed.
+	err = os.RemoveAll(srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkEntry(t, got, entry, "built")
+}
+
+func TestDirInvalidBuildID(t *testing.T) {
+	root := tempDir(t, "")
+	defer os.RemoveAll(root)
+	c := &Dir{Root: tempDir(t, root)}
+	entry := writeEntry(t, tempDir(t, root), "built")
+
+	for _, buildID := range []string{"", "../x", "a/b"} {
+		key := Key{ImportPath: "github.com/x", Name: "x", BuildID: buildID}
+		if _, err := c.Get(context.Background(), key); err == nil {
+			t.Errorf("Get with build ID %q succeeded, want error", buildID)
+		}
+		if err := c.Put(context.Background(), key, entry); err == nil {
+			t.Errorf("Put with build ID %q succeeded, want error", buildID)
+		}
+	}
+}
+
+// TestDirPutConcurrent stores the same build from several builds at once.
+func TestDirPutConcurrent(t *testing.T) {
+	root := tempDir(t, "")
+	defer os.RemoveAll(root)
+	c := &Dir{Root: tempDir(t, root)}
+	key := Key{ImportPath: "github.com/x", Name: "x", BuildID: "build-1"}
+	entry := writeEntry(t, tempDir(t, root), "built")
+
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			errs <- c.Put(context.Background(), key, entry)
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Put: %v", err)
+		}
+	}
+
+	got, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	checkEntry(t, got, entry, "built")
+	// Only the build is left, without any temporary directories.
+	files, err := ioutil.ReadDir(path.Dir(c.entryDir(key.BuildID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != key.BuildID {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("cache contains %q, want only %q", names, key.BuildID)
+	}
+}
+
+// TestDirVersions stores two builds of one package, which are both kept.
+func TestDirVersions(t *testing.T) {
+	root := tempDir(t, "")
+	defer os.RemoveAll(root)
+	c := &Dir{Root: tempDir(t, root)}
+	keys := []Key{
+		{ImportPath: "github.com/x", Name: "x", BuildID: "build-1"},
+		{ImportPath: "github.com/x", Name: "x", BuildID: "build-2"},
+	}
+	entries := []*Entry{
+		writeEntry(t, tempDir(t, root), "version 1"),
+		writeEntry(t, tempDir(t, root), "version 2"),
+	}
+	for i, key := range keys {
+		err := c.Put(context.Background(), key, entries[i])
+		if err != nil {
+			t.Fatalf("Put(%q): %v", key.BuildID, err)
+		}
+	}
+
+	for i, key := range keys {
+		got, err := c.Get(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key.BuildID, err)
+		}
+		checkEntry(t, got, entries[i], fmt.Sprintf("version %d", i+1))
+	}
+	usage, err := c.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, u := range usage {
+		ids = append(ids, u.ID)
+	}
+	sort.Strings(ids)
+	if want := []string{"build-1", "build-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Usage lists %q, want %q", ids, want)
+	}
+}
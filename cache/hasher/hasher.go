@@ -16,6 +16,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/hpidcock/gophertest/dag"
+	"github.com/hpidcock/gophertest/util"
 	"github.com/hpidcock/gophertest/version"
 )
 
@@ -31,6 +32,15 @@ type Hasher struct {
 	Logger   Logger
 	BuildCtx gobuild.Context
 	Tools    build.Tools
+
+	// ArchEnv selects the instruction set of the target, see util.ArchEnv.
+	ArchEnv string
+	// Cgo is the C toolchain for cgo packages.
+	Cgo util.CgoConfig
+	// TrimPath keys packages on their TrimDir and file names rather than
+	// where they are on disk, so the same source shares build IDs wherever it
+	// is checked out.
+	TrimPath bool
 }
 
 func (c *Hasher) Visit(ctx context.Context, node *dag.Node) error {
@@ -42,31 +52,36 @@ func (c *Hasher) Visit(ctx context.Context, node *dag.Node) error {
 		return errors.WithStack(err)
 	}
 
-	_, err = fmt.Fprintf(s, "%s:%s:%s:%s:%s:%s:%s:%s:%s:%s:%s:%t",
+	_, err = fmt.Fprintf(s, "%s:%s:%s:%s:%s:%s:%s:%s:%s:%s:%s:%t:%s:%t",
 		version.String,
 		runtime.Version(),
 		goCompilerVersion,
 		c.BuildCtx.Compiler,
 		c.BuildCtx.GOARCH,
 		c.BuildCtx.GOOS,
-		c.BuildCtx.GOPATH,
-		c.BuildCtx.GOROOT,
+		c.dir(c.BuildCtx.GOPATH),
+		c.dir(c.BuildCtx.GOROOT),
 		c.BuildCtx.InstallSuffix,
 		strings.Join(c.BuildCtx.ReleaseTags, ":"),
 		strings.Join(c.BuildCtx.BuildTags, ":"),
-		c.BuildCtx.CgoEnabled)
+		c.BuildCtx.CgoEnabled,
+		c.ArchEnv,
+		c.TrimPath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	_, err = fmt.Fprintf(s, "%s:%s:%s:%s:%t:%t:%t",
+	_, err = fmt.Fprintf(s, "%s:%s:%s:%s:%s:%t:%t:%t:%s:%q",
 		node.ImportPath,
 		node.Name,
-		node.SourceDir,
-		node.RootDir,
+		c.dir(node.SourceDir),
+		c.dir(node.RootDir),
+		node.TrimDir,
 		node.Goroot,
 		node.Standard,
-		node.Tests)
+		node.Tests,
+		node.CoverMode,
+		node.GcFlags)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -92,7 +107,7 @@ func (c *Hasher) Visit(ctx context.Context, node *dag.Node) error {
 			continue
 		}
 		s := sha256.New()
-		_, err := fmt.Fprintf(s, "%s:%s:%t\n", goFile.Dir, goFile.Filename, goFile.Test)
+		_, err := fmt.Fprintf(s, "%s:%s:%t:%s\n", c.dir(goFile.Dir), goFile.Filename, goFile.Test, goFile.CoverVar)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -113,7 +128,7 @@ func (c *Hasher) Visit(ctx context.Context, node *dag.Node) error {
 
 	for _, sFile := range node.SFiles {
 		s := sha256.New()
-		_, err := fmt.Fprintf(s, "%s:%s\n", sFile.Dir, sFile.Filename)
+		_, err := fmt.Fprintf(s, "%s:%s\n", c.dir(sFile.Dir), sFile.Filename)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -132,6 +147,66 @@ func (c *Hasher) Visit(ctx context.Context, node *dag.Node) error {
 		provenance = append(provenance, hashToString(s.Sum(nil)))
 	}
 
+	if len(node.CgoFiles) > 0 {
+		s := sha256.New()
+		_, err := fmt.Fprintf(s, "%q:%q:%q:%q:%q:%q:%q",
+			c.Cgo.CC,
+			c.Cgo.CPPFLAGS,
+			c.Cgo.CFLAGS,
+			c.Cgo.LDFLAGS,
+			c.cgoFlags(node, node.CgoCPPFLAGS),
+			c.cgoFlags(node, node.CgoCFLAGS),
+			c.cgoFlags(node, node.CgoLDFLAGS))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		provenance = append(provenance, hashToString(s.Sum(nil)))
+	}
+	for _, goFile := range node.CgoFiles {
+		h, err := c.hashFile(goFile.Dir, goFile.Filename)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		provenance = append(provenance, h)
+	}
+	for _, cFile := range node.CFiles {
+		h, err := c.hashFile(cFile.Dir, cFile.Filename)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		provenance = append(provenance, h)
+	}
+	for _, hFile := range node.HFiles {
+		h, err := c.hashFile(hFile.Dir, hFile.Filename)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		provenance = append(provenance, h)
+	}
+	for _, sysoFile := range node.SysoFiles {
+		h, err := c.hashFile(sysoFile.Dir, sysoFile.Filename)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		provenance = append(provenance, h)
+	}
+
+	if len(node.EmbedPatterns) > 0 {
+		s := sha256.New()
+		_, err := fmt.Fprintf(s, "%q", node.EmbedPatterns)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		provenance = append(provenance, hashToString(s.Sum(nil)))
+	}
+	for _, embedFile := range node.EmbedFiles {
+		h, err := c.hashFile(embedFile.Dir, embedFile.Filename)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		provenance = append(provenance, h)
+	}
+
 	sort.Strings(provenance)
 
 	s = sha256.New()
@@ -149,3 +224,43 @@ func (c *Hasher) Visit(ctx context.Context, node *dag.Node) error {
 
 	return nil
 }
+
+// hashFile hashes the name and content of a source file.
+func (c *Hasher) hashFile(dir string, filename string) (string, error) {
+	s := sha256.New()
+	_, err := fmt.Fprintf(s, "%s:%s\n", c.dir(dir), filename)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	f, err := os.OpenFile(path.Join(dir, filename), os.O_RDONLY, 0)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(s, f)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hashToString(s.Sum(nil)), nil
+}
+
+// dir is a directory to hash, which is left out with TrimPath.
+func (c *Hasher) dir(dir string) string {
+	if c.TrimPath {
+		return ""
+	}
+	return dir
+}
+
+// cgoFlags are the #cgo flags of a package to hash. With TrimPath the package
+// directory substituted for ${SRCDIR} is replaced by its TrimDir.
+func (c *Hasher) cgoFlags(node *dag.Node, flags []string) []string {
+	if !c.TrimPath {
+		return flags
+	}
+	trimmed := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		trimmed = append(trimmed, strings.ReplaceAll(flag, node.SourceDir, node.TrimDir))
+	}
+	return trimmed
+}
@@ -0,0 +1,81 @@
+package hasher
+
+import (
+	"context"
+	gobuild "go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/hpidcock/gophertest/dag"
+)
+
+// TestTrimPathBuildID hashes the same package checked out in two directories,
+// which only share a build ID with TrimPath.
+func TestTrimPathBuildID(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := ioutil.TempDir("", "gophertest-hasher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"x.go":      "package x\n",
+		"x_amd64.s": "TEXT ·x(SB),0,$0\n",
+		"c.go":      "package x\n\n// #cgo CFLAGS: -I${SRCDIR}/include\nimport \"C\"\n",
+		"c.c":       "int c;\n",
+	}
+	buildID := func(trimPath bool, checkout string) string {
+		dir := path.Join(root, checkout)
+		err := os.MkdirAll(dir, 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range files {
+			err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		node := &dag.Node{
+			ImportPath: "example.com/x",
+			NodeBits: &dag.NodeBits{
+				Name:      "x",
+				SourceDir: dir,
+				RootDir:   dir,
+				TrimDir:   "example.com/x",
+				GoFiles:   []dag.GoFile{{Dir: dir, Filename: "x.go"}},
+				SFiles:    []dag.SFile{{Dir: dir, Filename: "x_amd64.s"}},
+				CgoFiles:  []dag.GoFile{{Dir: dir, Filename: "c.go"}},
+				CFiles:    []dag.CFile{{Dir: dir, Filename: "c.c"}},
+				CgoCFLAGS: []string{"-I" + dir + "/include"},
+			},
+		}
+		h := &Hasher{
+			BuildCtx: gobuild.Default,
+			Tools:    build.DefaultTools,
+			TrimPath: trimPath,
+		}
+		err = h.Visit(context.Background(), node)
+		if err != nil {
+			t.Fatalf("hashing %s: %v", dir, err)
+		}
+		return node.Meta[0].(*HashMeta).BuildID
+	}
+
+	if a, b := buildID(true, "a"), buildID(true, "b"); a != b {
+		t.Errorf("with TrimPath build IDs are %q and %q, want them the same", a, b)
+	}
+	if a, b := buildID(false, "a"), buildID(false, "b"); a == b {
+		t.Errorf("without TrimPath build IDs are both %q, want them to differ", a)
+	}
+	if a, b := buildID(true, "a"), buildID(false, "a"); a == b {
+		t.Errorf("build ID %q is the same with and without TrimPath", a)
+	}
+}
@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hpidcock/gophertest/util"
+)
+
+// HTTP is a Cache on an HTTP server, such as one started with gophertest
+// cache serve. Files are verified against their SHA-256 and kept in Dir, so
+// builds already fetched or stored are found without asking the server.
+type HTTP struct {
+	Logger Logger
+	// URL of the server.
+	URL string
+	Dir string
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+var _ Cache = (*HTTP)(nil)
+var _ Trimmer = (*HTTP)(nil)
+
+func (h *HTTP) Get(ctx context.Context, key Key) (*Entry, error) {
+	if !buildIDPattern.MatchString(key.BuildID) {
+		return nil, fmt.Errorf("invalid build id %q", key.BuildID)
+	}
+	buildDir := path.Join(h.Dir, key.BuildID)
+	manifestFilepath := path.Join(buildDir, "manifest.json")
+
+	manifestBytes, err := ioutil.ReadFile(manifestFilepath)
+	if err == nil {
+		manifest := &remoteManifest{}
+		err = json.Unmarshal(manifestBytes, manifest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %q", manifestFilepath)
+		}
+		if entry := localEntry(buildDir, manifest); entry != nil {
+			err = markUsed(manifestFilepath, time.Now())
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return entry, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+
+	entry, err := h.fetch(ctx, key, buildDir)
+	if err != nil {
+		// The package is built instead when the server cannot provide it.
+		h.Logger.Infof("fetching %q from cache: %v", key.ImportPath, err)
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// fetch a build from the server into buildDir, returning nil if the server
+// does not have it.
+func (h *HTTP) fetch(ctx context.Context, key Key, buildDir string) (*Entry, error) {
+	resp, err := h.do(ctx, http.MethodGet, buildsPath+key.BuildID, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	manifestBytes, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching build %s: %s", key.BuildID, resp.Status)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	manifest := &remoteManifest{}
+	err = json.Unmarshal(manifestBytes, manifest)
+	if err == nil {
+		err = manifest.validate()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading manifest for build %s", key.BuildID)
+	}
+
+	err = os.MkdirAll(buildDir, 0777)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	h.Logger.Infof("downloading %q", key.ImportPath)
+	for _, f := range append([]remoteFile{manifest.Object}, manifest.Sources...) {
+		err = h.download(ctx, f, path.Join(buildDir, f.Name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "downloading %s for %q", f.Name, key.ImportPath)
+		}
+	}
+	// The manifest is written last, so a local build is only found once all
+	// of its files are.
+	err = writeFileSHA256(path.Join(buildDir, "manifest.json"), bytes.NewReader(manifestBytes), sha256Hex(manifestBytes))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return localEntry(buildDir, manifest), nil
+}
+
+// Put keeps the build in Dir and stores it on the server. The server is
+// shared and only speeds builds up, so failing to store the build there is
+// logged rather than failing the build.
+func (h *HTTP) Put(ctx context.Context, key Key, entry *Entry) error {
+	if !buildIDPattern.MatchString(key.BuildID) {
+		return fmt.Errorf("invalid build id %q", key.BuildID)
+	}
+	buildDir := path.Join(h.Dir, key.BuildID)
+	err := os.MkdirAll(buildDir, 0777)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	manifest := &remoteManifest{}
+	files := []string{entry.Object}
+	names := []string{fmt.Sprintf("%s.obj", key.Name)}
+	for _, source := range entry.Sources {
+		files = append(files, source)
+		names = append(names, path.Base(source))
+	}
+	for i, filename := range files {
+		sum, err := fileSHA256(filename)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		f := remoteFile{
+			Name:   names[i],
+			SHA256: sum,
+		}
+		if i == 0 {
+			manifest.Object = f
+		} else {
+			manifest.Sources = append(manifest.Sources, f)
+		}
+		err = util.FileCopy(filename, path.Join(buildDir, f.Name))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	err = manifest.validate()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// The manifest is written last, as in Get, so the local build is only
+	// found once all of its files are.
+	err = writeFileSHA256(path.Join(buildDir, "manifest.json"), bytes.NewReader(manifestBytes), sha256Hex(manifestBytes))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = h.store(ctx, key, manifest, manifestBytes, files)
+	if err != nil {
+		h.Logger.Infof("storing %q in cache: %v", key.ImportPath, err)
+	}
+	return nil
+}
+
+// store uploads the files of a build followed by its manifest.
+func (h *HTTP) store(ctx context.Context, key Key, manifest *remoteManifest, manifestBytes []byte, files []string) error {
+	for i, f := range append([]remoteFile{manifest.Object}, manifest.Sources...) {
+		err := h.upload(ctx, f, files[i])
+		if err != nil {
+			return errors.Wrapf(err, "uploading %s", f.Name)
+		}
+	}
+	resp, err := h.do(ctx, http.MethodPut, buildsPath+key.BuildID, bytes.NewReader(manifestBytes))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storing build %s: %s", key.BuildID, resp.Status)
+	}
+	return nil
+}
+
+// Usage lists each build kept in Dir by its build ID.
+func (h *HTTP) Usage() ([]Usage, error) {
+	dirs, err := ioutil.ReadDir(h.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	usage := []Usage{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || !buildIDPattern.MatchString(dir.Name()) {
+			continue
+		}
+		buildDir := path.Join(h.Dir, dir.Name())
+		files, err := ioutil.ReadDir(buildDir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		u := Usage{
+			ID: dir.Name(),
+			// Without a manifest the download did not finish.
+			Used: dir.ModTime(),
+		}
+		for _, f := range files {
+			u.Size += f.Size()
+			if f.Name() == "manifest.json" {
+				u.Used = f.ModTime()
+			}
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// Remove a build from Dir.
+func (h *HTTP) Remove(id string) error {
+	if !buildIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid build id %q", id)
+	}
+	err := os.RemoveAll(path.Join(h.Dir, id))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// download an object, checking its ETag and content match its SHA-256.
+func (h *HTTP) download(ctx context.Context, f remoteFile, filename string) error {
+	resp, err := h.do(ctx, http.MethodGet, objectsPath+f.SHA256, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching object %s: %s", f.SHA256, resp.Status)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && etag != `"`+f.SHA256+`"` {
+		return fmt.Errorf("fetching object %s: unexpected ETag %s", f.SHA256, etag)
+	}
+	err = writeFileSHA256(filename, resp.Body, f.SHA256)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// upload an object unless the server already has it.
+func (h *HTTP) upload(ctx context.Context, f remoteFile, filename string) error {
+	resp, err := h.do(ctx, http.MethodHead, objectsPath+f.SHA256, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+	resp, err = h.do(ctx, http.MethodPut, objectsPath+f.SHA256, file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storing object %s: %s", f.SHA256, resp.Status)
+	}
+	return nil
+}
+
+func (h *HTTP) do(ctx context.Context, method string, p string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(h.URL, "/")+p, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// localEntry is the build in dir, or nil if any of its files are missing.
+func localEntry(dir string, manifest *remoteManifest) *Entry {
+	entry := &Entry{
+		Object: path.Join(dir, manifest.Object.Name),
+	}
+	for _, f := range manifest.Sources {
+		entry.Sources = append(entry.Sources, path.Join(dir, f.Name))
+	}
+	for _, filename := range append([]string{entry.Object}, entry.Sources...) {
+		if _, err := os.Stat(filename); err != nil {
+			return nil
+		}
+	}
+	return entry
+}
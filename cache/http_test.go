@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Infof(format string, args ...interface{}) {
+	l.t.Logf(format, args...)
+}
+
+func tempDir(t *testing.T, parent string) string {
+	dir, err := ioutil.TempDir(parent, "gophertest-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, filename string, content string) {
+	err := ioutil.WriteFile(filename, []byte(content), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, filename string) string {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestHTTPRoundTrip(t *testing.T) {
+	root := tempDir(t, "")
+	defer os.RemoveAll(root)
+	logger := testLogger{t}
+	server := httptest.NewServer(&Server{Logger: logger, Dir: tempDir(t, root)})
+	defer server.Close()
+
+	srcDir := tempDir(t, root)
+	entry := &Entry{
+		Object:  path.Join(srcDir, "x.obj"),
+		Sources: []string{path.Join(srcDir, "x.go"), path.Join(srcDir, "x_amd64.s"), path.Join(srcDir, "x_amd64.syso")},
+	}
+	writeFile(t, entry.Object, "object")
+	writeFile(t, entry.Sources[0], "package x")
+	writeFile(t, entry.Sources[1], "TEXT x")
+	writeFile(t, entry.Sources[2], "ELF")
+	key := Key{ImportPath: "github.com/x", Name: "x", BuildID: "build-1"}
+
+	storer := &HTTP{Logger: logger, URL: server.URL, Dir: tempDir(t, root)}
+	err := storer.Put(context.Background(), key, entry)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Another machine, without the build in its own directory.
+	puller := &HTTP{Logger: logger, URL: server.URL, Dir: tempDir(t, root)}
+	got, err := puller.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got == nil {
+		t.Fatalf("Get: missing build")
+	}
+	if content := readFile(t, got.Object); content != "object" {
+		t.Errorf("object is %q, want %q", content, "object")
+	}
+	if len(got.Sources) != 3 {
+		t.Fatalf("got %d sources, want 3", len(got.Sources))
+	}
+	for i, want := range []string{"package x", "TEXT x", "ELF"} {
+		if path.Base(got.Sources[i]) != path.Base(entry.Sources[i]) {
+			t.Errorf("source %d is %q, want %q", i, path.Base(got.Sources[i]), path.Base(entry.Sources[i]))
+		}
+		if content := readFile(t, got.Sources[i]); content != want {
+			t.Errorf("source %d is %q, want %q", i, content, want)
+		}
+	}
+
+	// The build is now found locally.
+	server.Close()
+	got, err = puller.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get after server closed: %v", err)
+	}
+	if got == nil {
+		t.Fatalf("Get after server closed: missing local build")
+	}
+}
+
+func TestHTTPGetMiss(t *testing.T) {
+	root := tempDir(t, "")
+	defer os.RemoveAll(root)
+	logger := testLogger{t}
+	server := httptest.NewServer(&Server{Logger: logger, Dir: tempDir(t, root)})
+	defer server.Close()
+	key := Key{ImportPath: "github.com/x", Name: "x", BuildID: "build-1"}
+
+	h := &HTTP{Logger: logger, URL: server.URL, Dir: tempDir(t, root)}
+	got, err := h.Get(context.Background(), key)
+	if got != nil || err != nil {
+		t.Errorf("Get of an unknown build = %v, %v, want a miss", got, err)
+	}
+
+	server.Close()
+	got, err = h.Get(context.Background(), key)
+	if got != nil || err != nil {
+		t.Errorf("Get from a stopped server = %v, %v, want a miss", got, err)
+	}
+}
+
+func TestHTTPPutServerDown(t *testing.T) {
+	root := tempDir(t, "")
+	defer os.RemoveAll(root)
+	logger := testLogger{t}
+	server := httptest.NewServer(&Server{Logger: logger, Dir: tempDir(t, root)})
+	server.Close()
+
+	srcDir := tempDir(t, root)
+	entry := &Entry{
+		Object:  path.Join(srcDir, "x.obj"),
+		Sources: []string{path.Join(srcDir, "x.go")},
+	}
+	writeFile(t, entry.Object, "object")
+	writeFile(t, entry.Sources[0], "package x")
+	key := Key{ImportPath: "github.com/x", Name: "x", BuildID: "build-1"}
+
+	h := &HTTP{Logger: logger, URL: server.URL, Dir: tempDir(t, root)}
+	err := h.Put(context.Background(), key, entry)
+	if err != nil {
+		t.Fatalf("Put to a stopped server: %v", err)
+	}
+
+	// The build is still kept locally.
+	got, err := h.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got == nil {
+		t.Fatalf("Get: missing local build")
+	}
+	if content := readFile(t, got.Object); content != "object" {
+		t.Errorf("object is %q, want %q", content, "object")
+	}
+}
@@ -1,19 +1,16 @@
 package puller
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	gobuild "go/build"
-	"io/ioutil"
-	"os"
 	"path"
 	"strings"
 
 	"github.com/gophertest/build"
+	"github.com/hpidcock/gophertest/cache"
 	"github.com/hpidcock/gophertest/cache/hasher"
 	"github.com/hpidcock/gophertest/dag"
-	"github.com/hpidcock/gophertest/util"
 	"github.com/pkg/errors"
 )
 
@@ -26,8 +23,8 @@ type Puller struct {
 	BuildCtx gobuild.Context
 	Tools    build.Tools
 
-	CacheDir string
-	WorkDir  string
+	Cache   cache.Cache
+	WorkDir string
 }
 
 func (p *Puller) Visit(ctx context.Context, node *dag.Node) error {
@@ -46,82 +43,48 @@ func (p *Puller) Visit(ctx context.Context, node *dag.Node) error {
 		return fmt.Errorf("missing build id")
 	}
 
-	cacheDir := util.PackageCacheDir(p.CacheDir, node.ImportPath)
-
-	manifestFilepath := path.Join(cacheDir, fmt.Sprintf("%s.manifest", node.Name))
-	if _, err := os.Stat(manifestFilepath); os.IsNotExist(err) {
-		return nil
-	}
-
-	manifestBytes, err := ioutil.ReadFile(manifestFilepath)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	manifest := strings.Split(string(manifestBytes), "\n")
-
-	cacheObj := path.Join(cacheDir, fmt.Sprintf("%s.obj", node.Name))
-	if _, err := os.Stat(cacheObj); os.IsNotExist(err) {
-		return nil
-	} else if err != nil {
-		return errors.WithStack(err)
-	}
-
-	out := &bytes.Buffer{}
-	readBuildID, err := p.Tools.BuildID(build.BuildIDArgs{
-		Context:    p.BuildCtx,
-		Stderr:     out,
-		ObjectFile: cacheObj,
-		Write:      false,
+	entry, err := p.Cache.Get(ctx, cache.Key{
+		ImportPath: node.ImportPath,
+		Name:       node.Name,
+		BuildID:    buildID,
 	})
 	if err != nil {
-		p.Logger.Infof("failed to read build id for %q: %s", node.Name, err.Error())
-		return nil
+		return errors.Wrapf(err, "pulling %q", node.ImportPath)
 	}
-	if !strings.Contains(readBuildID, buildID) {
+	if entry == nil {
 		return nil
 	}
 
-	overwriteGoFiles := map[string]struct{}{}
-	for _, filename := range manifest {
-		if !strings.HasSuffix(filename, ".go") {
-			continue
-		}
-		filepath := path.Join(cacheDir, filename)
-		if _, err := os.Stat(filepath); os.IsNotExist(err) {
-			return nil
-		} else if err != nil {
-			return errors.WithMessagef(err, "looking for cached go file %q", filename)
+	// Rewritten sources replace the originals of the same name.
+	overwriteGoFiles := map[string]string{}
+	overwriteSFiles := map[string]string{}
+	overwriteSysoFiles := map[string]string{}
+	for _, source := range entry.Sources {
+		dir, filename := path.Dir(source), path.Base(source)
+		switch path.Ext(filename) {
+		case ".go":
+			overwriteGoFiles[filename] = dir
+		case ".s":
+			overwriteSFiles[filename] = dir
+		case ".syso":
+			overwriteSysoFiles[filename] = dir
 		}
-		overwriteGoFiles[filename] = struct{}{}
-	}
-
-	overwriteSFiles := map[string]struct{}{}
-	for _, filename := range manifest {
-		if !strings.HasSuffix(filename, ".s") {
-			continue
-		}
-		filepath := path.Join(cacheDir, filename)
-		if _, err := os.Stat(filepath); os.IsNotExist(err) {
-			return nil
-		} else if err != nil {
-			return errors.WithMessagef(err, "looking for cached asm file %q", filename)
-		}
-		overwriteSFiles[filename] = struct{}{}
 	}
 
 	replacementGoFiles := []dag.GoFile(nil)
 	for _, v := range node.GoFiles {
-		if _, ok := overwriteGoFiles[v.Filename]; !ok {
+		dir, ok := overwriteGoFiles[v.Filename]
+		if !ok {
 			replacementGoFiles = append(replacementGoFiles, v)
 			continue
 		}
 		delete(overwriteGoFiles, v.Filename)
-		v.Dir = cacheDir
+		v.Dir = dir
 		replacementGoFiles = append(replacementGoFiles, v)
 	}
-	for k := range overwriteGoFiles {
+	for k, dir := range overwriteGoFiles {
 		goFile := dag.GoFile{
-			Dir:      cacheDir,
+			Dir:      dir,
 			Filename: k,
 			Test:     strings.HasSuffix(k, "_test.go"),
 		}
@@ -130,25 +93,46 @@ func (p *Puller) Visit(ctx context.Context, node *dag.Node) error {
 
 	replacementSFiles := []dag.SFile(nil)
 	for _, v := range node.SFiles {
-		if _, ok := overwriteSFiles[v.Filename]; !ok {
+		dir, ok := overwriteSFiles[v.Filename]
+		if !ok {
 			replacementSFiles = append(replacementSFiles, v)
 			continue
 		}
 		delete(overwriteSFiles, v.Filename)
-		v.Dir = cacheDir
+		v.Dir = dir
 		replacementSFiles = append(replacementSFiles, v)
 	}
-	for k := range overwriteSFiles {
+	for k, dir := range overwriteSFiles {
 		goFile := dag.SFile{
-			Dir:      cacheDir,
+			Dir:      dir,
 			Filename: k,
 		}
 		replacementSFiles = append(replacementSFiles, goFile)
 	}
 
-	node.Shlib = cacheObj
+	replacementSysoFiles := []dag.SysoFile(nil)
+	for _, v := range node.SysoFiles {
+		dir, ok := overwriteSysoFiles[v.Filename]
+		if !ok {
+			replacementSysoFiles = append(replacementSysoFiles, v)
+			continue
+		}
+		delete(overwriteSysoFiles, v.Filename)
+		v.Dir = dir
+		replacementSysoFiles = append(replacementSysoFiles, v)
+	}
+	for k, dir := range overwriteSysoFiles {
+		sysoFile := dag.SysoFile{
+			Dir:      dir,
+			Filename: k,
+		}
+		replacementSysoFiles = append(replacementSysoFiles, sysoFile)
+	}
+
+	node.Shlib = entry.Object
 	node.GoFiles = replacementGoFiles
 	node.SFiles = replacementSFiles
+	node.SysoFiles = replacementSysoFiles
 
 	return nil
 }
@@ -0,0 +1,103 @@
+package puller
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/hpidcock/gophertest/builder"
+	"github.com/hpidcock/gophertest/cache"
+	"github.com/hpidcock/gophertest/cache/hasher"
+	"github.com/hpidcock/gophertest/cache/storer"
+	"github.com/hpidcock/gophertest/dag"
+)
+
+// TestStorePullSyso stores a package with a system object rewritten during the
+// build and pulls it into a package that has not been built.
+func TestStorePullSyso(t *testing.T) {
+	root, err := ioutil.TempDir("", "gophertest-puller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	srcDir := path.Join(root, "src")
+	workDir := path.Join(root, "work")
+	files := map[string]string{
+		path.Join(srcDir, "x.go"):          "package x",
+		path.Join(srcDir, "x_amd64.syso"):  "original",
+		path.Join(srcDir, "y_amd64.syso"):  "unchanged",
+		path.Join(workDir, "x_amd64.syso"): "rewritten",
+		path.Join(workDir, "x.obj"):        "object",
+	}
+	for filename, content := range files {
+		err := os.MkdirAll(path.Dir(filename), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filename, []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &cache.Dir{Root: path.Join(root, "cache")}
+	node := func() *dag.Node {
+		return &dag.Node{
+			ImportPath: "example.com/x",
+			NodeBits: &dag.NodeBits{
+				Name:      "x",
+				SourceDir: srcDir,
+				GoFiles:   []dag.GoFile{{Dir: srcDir, Filename: "x.go"}},
+				SysoFiles: []dag.SysoFile{
+					{Dir: srcDir, Filename: "x_amd64.syso"},
+					{Dir: srcDir, Filename: "y_amd64.syso"},
+				},
+				Meta: []interface{}{&hasher.HashMeta{BuildID: "build-1"}},
+			},
+		}
+	}
+
+	built := node()
+	built.Shlib = path.Join(workDir, "x.obj")
+	built.SysoFiles[0].Dir = workDir
+	built.Meta = append(built.Meta, &builder.BuildMeta{Rebuilt: true})
+	s := &storer.Storer{Cache: c}
+	err = s.Visit(context.Background(), built)
+	if err != nil {
+		t.Fatalf("storing: %v", err)
+	}
+
+	pulled := node()
+	p := &Puller{Cache: c}
+	err = p.Visit(context.Background(), pulled)
+	if err != nil {
+		t.Fatalf("pulling: %v", err)
+	}
+	if pulled.Shlib == "" {
+		t.Fatalf("package not pulled")
+	}
+	if !reflect.DeepEqual(pulled.GoFiles, node().GoFiles) {
+		t.Errorf("go files are %v, want the originals", pulled.GoFiles)
+	}
+	if len(pulled.SysoFiles) != 2 {
+		t.Fatalf("got syso files %v, want 2", pulled.SysoFiles)
+	}
+	for i, want := range []string{"rewritten", "unchanged"} {
+		f := pulled.SysoFiles[i]
+		b, err := ioutil.ReadFile(path.Join(f.Dir, f.Filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s is %q, want %q", f.Filename, b, want)
+		}
+	}
+	// The rewritten file is read from the cache, not the build.
+	if dir := pulled.SysoFiles[0].Dir; dir == workDir || dir == srcDir {
+		t.Errorf("rewritten syso file read from %s, want the cache", dir)
+	}
+}
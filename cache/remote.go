@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+)
+
+// An HTTP cache stores files by their SHA-256 under /objects/ and a manifest
+// for each build under /builds/ listing the files by SHA-256.
+const (
+	objectsPath = "/objects/"
+	buildsPath  = "/builds/"
+)
+
+// remoteManifest lists the files of a build in an HTTP cache.
+type remoteManifest struct {
+	Object  remoteFile
+	Sources []remoteFile
+}
+
+type remoteFile struct {
+	Name   string
+	SHA256 string
+}
+
+var (
+	buildIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	sha256Pattern  = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+func (m *remoteManifest) validate() error {
+	for _, f := range append([]remoteFile{m.Object}, m.Sources...) {
+		if f.Name == "" || f.Name != path.Base(f.Name) || f.Name == "." || f.Name == ".." {
+			return fmt.Errorf("invalid file name %q", f.Name)
+		}
+		if !sha256Pattern.MatchString(f.SHA256) {
+			return fmt.Errorf("invalid sha256 %q for %q", f.SHA256, f.Name)
+		}
+	}
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// fileSHA256 is the hex SHA-256 of a file's content.
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := sha256.New()
+	_, err = io.Copy(s, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(s.Sum(nil)), nil
+}
+
+// writeFileSHA256 writes r to filename through a temporary file, which is
+// only renamed into place if the content has the SHA-256 want.
+func writeFileSHA256(filename string, r io.Reader, want string) error {
+	f, err := ioutil.TempFile(path.Dir(filename), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	s := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, s), r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(s.Sum(nil)); got != want {
+		return fmt.Errorf("sha256 mismatch: got %s, want %s", got, want)
+	}
+	return os.Rename(f.Name(), filename)
+}
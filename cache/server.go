@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxManifestSize limits the size of a build manifest put to a Server.
+const maxManifestSize = 1 << 20
+
+// Server serves an HTTP cache from a directory.
+type Server struct {
+	Logger Logger
+	Dir    string
+}
+
+var _ http.Handler = (*Server)(nil)
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, objectsPath):
+		s.serveObject(w, r, strings.TrimPrefix(r.URL.Path, objectsPath))
+	case strings.HasPrefix(r.URL.Path, buildsPath):
+		s.serveBuild(w, r, strings.TrimPrefix(r.URL.Path, buildsPath))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, sum string) {
+	if !sha256Pattern.MatchString(sum) {
+		http.NotFound(w, r)
+		return
+	}
+	filename := path.Join(s.Dir, "objects", sum[:2], sum)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		// Objects never change, so the SHA-256 is the ETag.
+		w.Header().Set("ETag", `"`+sum+`"`)
+		s.serveFile(w, r, filename)
+	case http.MethodPut:
+		err := os.MkdirAll(path.Dir(filename), 0777)
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		err = writeFileSHA256(filename, r.Body, sum)
+		if err != nil {
+			s.error(w, r, err, http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveBuild(w http.ResponseWriter, r *http.Request, buildID string) {
+	if !buildIDPattern.MatchString(buildID) {
+		http.NotFound(w, r)
+		return
+	}
+	filename := path.Join(s.Dir, "builds", buildID)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/json")
+		s.serveFile(w, r, filename)
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+		if err != nil {
+			s.error(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if len(data) > maxManifestSize {
+			s.error(w, r, fmt.Errorf("manifest too large"), http.StatusRequestEntityTooLarge)
+			return
+		}
+		manifest := &remoteManifest{}
+		err = json.Unmarshal(data, manifest)
+		if err == nil {
+			err = manifest.validate()
+		}
+		if err != nil {
+			s.error(w, r, err, http.StatusBadRequest)
+			return
+		}
+		// A build is only listed once all of its files are stored.
+		for _, f := range append([]remoteFile{manifest.Object}, manifest.Sources...) {
+			_, err := os.Stat(path.Join(s.Dir, "objects", f.SHA256[:2], f.SHA256))
+			if err != nil {
+				s.error(w, r, fmt.Errorf("missing object %s for %s", f.SHA256, f.Name), http.StatusBadRequest)
+				return
+			}
+		}
+		err = os.MkdirAll(path.Dir(filename), 0777)
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		err = writeFileSHA256(filename, bytes.NewReader(data), sha256Hex(data))
+		if err != nil {
+			s.error(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, filename string) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s.error(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+func (s *Server) error(w http.ResponseWriter, r *http.Request, err error, code int) {
+	s.Logger.Infof("%s %s: %v", r.Method, r.URL.Path, err)
+	http.Error(w, err.Error(), code)
+}
@@ -4,13 +4,11 @@ import (
 	"context"
 	"fmt"
 	gobuild "go/build"
-	"io/ioutil"
-	"os"
 	"path"
-	"strings"
 
 	"github.com/hpidcock/gophertest/builder"
-	"github.com/hpidcock/gophertest/util"
+	"github.com/hpidcock/gophertest/cache"
+	"github.com/hpidcock/gophertest/cache/hasher"
 	"github.com/pkg/errors"
 
 	"github.com/gophertest/build"
@@ -26,19 +24,22 @@ type Storer struct {
 	BuildCtx gobuild.Context
 	Tools    build.Tools
 
-	CacheDir string
+	Cache cache.Cache
 }
 
-func (s *Storer) Visit(ctx context.Context, node *dag.Node) (errOut error) {
+func (s *Storer) Visit(ctx context.Context, node *dag.Node) error {
 	if node.ImportPath == "main" {
 		return nil
 	}
 
 	rebuilt := false
+	buildID := ""
 	for _, meta := range node.Meta {
 		switch m := meta.(type) {
 		case *builder.BuildMeta:
 			rebuilt = m.Rebuilt
+		case *hasher.HashMeta:
+			buildID = m.BuildID
 		}
 	}
 	if !rebuilt {
@@ -48,100 +49,40 @@ func (s *Storer) Visit(ctx context.Context, node *dag.Node) (errOut error) {
 	if node.Shlib == "" {
 		return fmt.Errorf("missing shlib")
 	}
-
-	cacheDir := util.PackageCacheDir(s.CacheDir, node.ImportPath)
-	err := os.MkdirAll(cacheDir, 0777)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	manifestFilepath := path.Join(cacheDir, fmt.Sprintf("%s.manifest", node.Name))
-	if _, err := os.Stat(manifestFilepath); os.IsNotExist(err) {
-		// Do nothing
-	} else if err != nil {
-		return errors.WithStack(err)
-	} else {
-		// Remove old files from previous cache.
-		manifestBytes, err := ioutil.ReadFile(manifestFilepath)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		manifest := strings.Split(string(manifestBytes), "\n")
-		for _, f := range manifest {
-			if f == "" {
-				continue
-			}
-			filepath := path.Join(cacheDir, f)
-			err := os.Remove(filepath)
-			if os.IsNotExist(err) {
-				continue
-			} else if err != nil {
-				return errors.WithStack(err)
-			}
-		}
+	if buildID == "" {
+		return fmt.Errorf("missing build id")
 	}
 
-	manifestFile, err := os.Create(manifestFilepath)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	defer func() {
-		err := manifestFile.Close()
-		if err != nil {
-			err = errors.WithStack(err)
-			if errOut != nil {
-				fmt.Println(err.Error())
-			} else {
-				errOut = err
-			}
-			return
-		}
-	}()
-
-	objFilename := fmt.Sprintf("%s.obj", node.Name)
-	_, err = fmt.Fprintln(manifestFile, objFilename)
-	if err != nil {
-		return errors.WithStack(err)
+	entry := &cache.Entry{
+		Object: node.Shlib,
 	}
-	objFilepath := path.Join(cacheDir, objFilename)
-	err = util.FileCopy(node.Shlib, objFilepath)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
 	for _, goFile := range node.GoFiles {
 		if goFile.Dir == node.SourceDir {
 			continue
 		}
-		_, err := fmt.Fprintln(manifestFile, goFile.Filename)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		err = util.FileCopy(
-			path.Join(goFile.Dir, goFile.Filename),
-			path.Join(cacheDir, goFile.Filename),
-		)
-		if err != nil {
-			return errors.WithStack(err)
-		}
+		entry.Sources = append(entry.Sources, path.Join(goFile.Dir, goFile.Filename))
 	}
-
 	for _, sFile := range node.SFiles {
 		if sFile.Dir == node.SourceDir {
 			continue
 		}
-		_, err := fmt.Fprintln(manifestFile, sFile.Filename)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		err = util.FileCopy(
-			path.Join(sFile.Dir, sFile.Filename),
-			path.Join(cacheDir, sFile.Filename),
-		)
-		if err != nil {
-			return errors.WithStack(err)
+		entry.Sources = append(entry.Sources, path.Join(sFile.Dir, sFile.Filename))
+	}
+	for _, sysoFile := range node.SysoFiles {
+		if sysoFile.Dir == node.SourceDir {
+			continue
 		}
+		entry.Sources = append(entry.Sources, path.Join(sysoFile.Dir, sysoFile.Filename))
 	}
 
+	key := cache.Key{
+		ImportPath: node.ImportPath,
+		Name:       node.Name,
+		BuildID:    buildID,
+	}
+	err := s.Cache.Put(ctx, key, entry)
+	if err != nil {
+		return errors.Wrapf(err, "storing %q", node.ImportPath)
+	}
 	return nil
 }
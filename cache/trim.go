@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// usedInterval is how stale an entry's access time must be before it is
+// updated, so most builds only read the cache.
+const usedInterval = time.Hour
+
+// Usage is an entry stored on local disk by a cache.
+type Usage struct {
+	// ID identifies the entry to the cache that stored it.
+	ID   string
+	Size int64
+	// Used is when the entry was last stored or fetched.
+	Used time.Time
+}
+
+// Trimmer is implemented by caches that keep entries on local disk.
+type Trimmer interface {
+	// Usage lists the stored entries.
+	Usage() ([]Usage, error)
+	// Remove an entry listed by Usage.
+	Remove(id string) error
+}
+
+// Limits bound the size of the entries kept by trimmers, zero is no limit.
+type Limits struct {
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+// TrimResult is what Trim removed and kept.
+type TrimResult struct {
+	Removed int
+	Freed   int64
+	Kept    int
+	Size    int64
+}
+
+// Trim removes the entries of the trimmers not used within limits.MaxAge, then
+// the least recently used entries until those kept are within limits.MaxSize.
+func Trim(trimmers []Trimmer, limits Limits, now time.Time) (TrimResult, error) {
+	type entry struct {
+		Usage
+		trimmer Trimmer
+	}
+	entries := []entry{}
+	for _, t := range trimmers {
+		usage, err := t.Usage()
+		if err != nil {
+			return TrimResult{}, errors.WithStack(err)
+		}
+		for _, u := range usage {
+			entries = append(entries, entry{u, t})
+		}
+	}
+	// Most recently used first.
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Used.After(entries[j].Used)
+	})
+
+	result := TrimResult{}
+	full := false
+	for _, e := range entries {
+		if limits.MaxSize > 0 && result.Size+e.Size > limits.MaxSize {
+			// Everything used before this entry is removed too.
+			full = true
+		}
+		if !full && (limits.MaxAge <= 0 || now.Sub(e.Used) <= limits.MaxAge) {
+			result.Kept++
+			result.Size += e.Size
+			continue
+		}
+		err := e.trimmer.Remove(e.ID)
+		if err != nil {
+			return result, errors.Wrapf(err, "removing %q", e.ID)
+		}
+		result.Removed++
+		result.Freed += e.Size
+	}
+	return result, nil
+}
+
+// markUsed updates the modification time of filename, which records when an
+// entry was last used.
+func markUsed(filename string, now time.Time) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if now.Sub(info.ModTime()) < usedInterval {
+		return nil
+	}
+	return os.Chtimes(filename, now, now)
+}
+
+// filesSize is the total size of the files, missing files are skipped.
+func filesSize(filenames []string) (int64, error) {
+	size := int64(0)
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
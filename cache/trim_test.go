@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type testTrimmer struct {
+	usage   []Usage
+	removed []string
+}
+
+func (t *testTrimmer) Usage() ([]Usage, error) {
+	return t.usage, nil
+}
+
+func (t *testTrimmer) Remove(id string) error {
+	t.removed = append(t.removed, id)
+	return nil
+}
+
+func TestTrim(t *testing.T) {
+	now := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	usage := func() ([]Usage, []Usage) {
+		return []Usage{
+			{ID: "a", Size: 10, Used: now},
+			{ID: "c", Size: 10, Used: now.Add(-2 * day)},
+			{ID: "e", Size: 1, Used: now.Add(-40 * day)},
+		}, []Usage{
+			{ID: "b", Size: 10, Used: now.Add(-day)},
+			{ID: "d", Size: 30, Used: now.Add(-3 * day)},
+		}
+	}
+	tests := []struct {
+		name        string
+		limits      Limits
+		wantRemoved []string
+		wantResult  TrimResult
+	}{{
+		name:       "no limits",
+		limits:     Limits{},
+		wantResult: TrimResult{Kept: 5, Size: 61},
+	}, {
+		name:        "max age",
+		limits:      Limits{MaxAge: 30 * day},
+		wantRemoved: []string{"e"},
+		wantResult:  TrimResult{Removed: 1, Freed: 1, Kept: 4, Size: 60},
+	}, {
+		name:        "max size",
+		limits:      Limits{MaxSize: 35},
+		wantRemoved: []string{"d", "e"},
+		wantResult:  TrimResult{Removed: 2, Freed: 31, Kept: 3, Size: 30},
+	}, {
+		name:        "max size removes everything used earlier",
+		limits:      Limits{MaxSize: 15},
+		wantRemoved: []string{"b", "c", "d", "e"},
+		wantResult:  TrimResult{Removed: 4, Freed: 51, Kept: 1, Size: 10},
+	}, {
+		name:        "both",
+		limits:      Limits{MaxSize: 100, MaxAge: 2 * day},
+		wantRemoved: []string{"d", "e"},
+		wantResult:  TrimResult{Removed: 2, Freed: 31, Kept: 3, Size: 30},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, second := usage()
+			trimmers := []*testTrimmer{{usage: first}, {usage: second}}
+			result, err := Trim([]Trimmer{trimmers[0], trimmers[1]}, test.limits, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != test.wantResult {
+				t.Errorf("Trim = %+v, want %+v", result, test.wantResult)
+			}
+			removed := append(append([]string(nil), trimmers[0].removed...), trimmers[1].removed...)
+			sort.Strings(removed)
+			if !reflect.DeepEqual(removed, test.wantRemoved) {
+				t.Errorf("removed %q, want %q", removed, test.wantRemoved)
+			}
+		})
+	}
+}
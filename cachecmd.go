@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hpidcock/gophertest/cache"
+	"github.com/hpidcock/gophertest/logging"
+	"github.com/hpidcock/gophertest/util"
+)
+
+const (
+	defaultCacheMaxSize = "10GiB"
+	defaultCacheMaxAge  = "720h"
+	// trimInterval is how often a build trims the cache.
+	trimInterval = 24 * time.Hour
+	// trimStamp records when a target's cache was last trimmed.
+	trimStamp = "trim.txt"
+)
+
+// CacheMain runs the gophertest cache subcommands.
+func CacheMain(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: gophertest cache serve|trim [flags]\n")
+		os.Exit(-1)
+	}
+	switch args[0] {
+	case "serve":
+		return cacheServe(args[1:])
+	case "trim":
+		return cacheTrim(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown cache command %q\n", args[0])
+		os.Exit(-1)
+	}
+	return nil
+}
+
+// cacheServe serves a directory as an HTTP cache for -cache-url.
+func cacheServe(args []string) error {
+	flags := flag.NewFlagSet("gophertest cache serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	dir := flags.String("dir", "", "directory to store the cache in (default gophertest/server in the user cache directory)")
+	verbose := flags.Bool("v", false, "verbose logging")
+	err := flags.Parse(args)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	logger := logging.Logger(nil)
+	if *verbose {
+		logger = &logging.StdLogger{}
+	} else {
+		logger = &logging.NullLogger{}
+	}
+
+	if *dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		*dir = path.Join(cacheDir, "gophertest", "server")
+	}
+	err = os.MkdirAll(*dir, 0777)
+	if err != nil {
+		return errors.Wrap(err, "creating cache dir")
+	}
+
+	logger.Infof("serving %q on %s", *dir, *addr)
+	err = http.ListenAndServe(*addr, &cache.Server{
+		Logger: logger,
+		Dir:    *dir,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// cacheTrim trims the package cache of every target.
+func cacheTrim(args []string) error {
+	flags := flag.NewFlagSet("gophertest cache trim", flag.ExitOnError)
+	maxSize := flags.String("max-size", env("GOPHERTEST_CACHE_MAX_SIZE", defaultCacheMaxSize), "size to trim the cache of each target to, 0 is no limit")
+	maxAge := flags.String("max-age", env("GOPHERTEST_CACHE_MAX_AGE", defaultCacheMaxAge), "remove packages not used for this long, 0 is no limit")
+	verbose := flags.Bool("v", false, "verbose logging")
+	err := flags.Parse(args)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	logger := logging.Logger(nil)
+	if *verbose {
+		logger = &logging.StdLogger{}
+	} else {
+		logger = &logging.NullLogger{}
+	}
+
+	limits, err := cacheLimits(*maxSize, *maxAge)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	root, err := util.CacheRoot()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// Every target is trimmed, whether or not a build has trimmed it before.
+	dirs, err := util.TargetCacheDirs(root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, cacheDir := range dirs {
+		lock, err := util.LockDirectory(cacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %q: %v\n", cacheDir, err)
+			continue
+		}
+		err = trimCache(logger, cacheDir, limits, true)
+		lock.Unlock()
+		if err != nil {
+			return errors.Wrapf(err, "trimming %q", cacheDir)
+		}
+	}
+	return nil
+}
+
+// cacheLimits parses the -cache-max-size and -cache-max-age values.
+func cacheLimits(maxSize string, maxAge string) (cache.Limits, error) {
+	limits := cache.Limits{}
+	size, err := util.ParseSize(maxSize)
+	if err != nil {
+		return limits, errors.WithStack(err)
+	}
+	limits.MaxSize = size
+	if maxAge != "0" {
+		age, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return limits, errors.WithStack(err)
+		}
+		limits.MaxAge = age
+	}
+	return limits, nil
+}
+
+// remoteCacheDir keeps the packages fetched from -cache-url.
+func remoteCacheDir(cacheDir string) string {
+	return path.Join(cacheDir, "remote")
+}
+
+// trimCache trims the package caches in cacheDir, which must be locked. It
+// does nothing if they were trimmed within trimInterval, unless force is set.
+func trimCache(logger logging.Logger, cacheDir string, limits cache.Limits, force bool) error {
+	stamp := path.Join(cacheDir, trimStamp)
+	now := time.Now()
+	if info, err := os.Stat(stamp); !force && err == nil && now.Sub(info.ModTime()) < trimInterval {
+		return nil
+	}
+
+	result, err := cache.Trim([]cache.Trimmer{
+		&cache.Dir{Root: cacheDir},
+		&cache.HTTP{Dir: remoteCacheDir(cacheDir)},
+	}, limits, now)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	logger.Infof("trimmed %q: removed %d packages (%d bytes), kept %d packages (%d bytes)",
+		cacheDir, result.Removed, result.Freed, result.Kept, result.Size)
+
+	err = ioutil.WriteFile(stamp, []byte(now.Format(time.RFC3339)+"\n"), 0666)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package cover
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	gobuild "go/build"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/gophertest/build"
+	"github.com/pkg/errors"
+
+	"github.com/hpidcock/gophertest/dag"
+)
+
+type Logger interface {
+	Infof(format string, args ...interface{})
+}
+
+const (
+	ModeSet    = "set"
+	ModeCount  = "count"
+	ModeAtomic = "atomic"
+)
+
+// ValidMode reports if mode is understood by go tool cover.
+func ValidMode(mode string) bool {
+	switch mode {
+	case ModeSet, ModeCount, ModeAtomic:
+		return true
+	}
+	return false
+}
+
+// Mark the node for instrumentation, naming the counters for each of its non
+// test files. Nodes must be marked before hashing so covered builds are
+// cached separately.
+func Mark(node *dag.Node, mode string) {
+	node.CoverMode = mode
+	n := 0
+	for k, goFile := range node.GoFiles {
+		if goFile.Test || goFile.Generator != nil {
+			continue
+		}
+		goFile.CoverVar = fmt.Sprintf("GoCover_%d", n)
+		node.GoFiles[k] = goFile
+		n++
+	}
+}
+
+// Instrumenter rewrites the files of marked nodes with go tool cover.
+type Instrumenter struct {
+	Logger   Logger
+	BuildCtx gobuild.Context
+
+	WorkDir string
+}
+
+func (i *Instrumenter) Visit(ctx context.Context, node *dag.Node) error {
+	if node.CoverMode == "" {
+		return nil
+	}
+	if node.Shlib != "" {
+		return nil
+	}
+
+	i.Logger.Infof("instrumenting %q", node.ImportPath)
+
+	outDir := path.Join(i.WorkDir, "cover", node.ImportPath)
+	err := os.MkdirAll(outDir, 0777)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for k, goFile := range node.GoFiles {
+		if goFile.CoverVar == "" {
+			continue
+		}
+		err = i.cover(node.CoverMode, goFile.CoverVar, path.Join(goFile.Dir, goFile.Filename), path.Join(outDir, goFile.Filename))
+		if err != nil {
+			return errors.Wrapf(err, "instrumenting %q", path.Join(node.ImportPath, goFile.Filename))
+		}
+		goFile.Dir = outDir
+		node.GoFiles[k] = goFile
+	}
+
+	return nil
+}
+
+func (i *Instrumenter) cover(mode string, coverVar string, inFile string, outFile string) error {
+	tool := path.Join(gobuild.ToolDir, "cover")
+	cmdArgs := []string{"-mode", mode, "-var", coverVar, "-o", outFile, inFile}
+	if build.DebugLog {
+		fmt.Printf("%s %s\n", tool, strings.Join(cmdArgs, " "))
+	}
+	out := &bytes.Buffer{}
+	cmd := exec.Command(tool, cmdArgs...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err := cmd.Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed instrumenting %s: %v", inFile, out)
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package cover
+
+import (
+	"strings"
+
+	"github.com/hpidcock/gophertest/buildflags"
+)
+
+// MatchPackages returns a function reporting if a package matches any of the
+// patterns, see buildflags.MatchPattern. Relative patterns are resolved from
+// dir.
+func MatchPackages(dir string, patterns []string) func(pkg buildflags.Package) bool {
+	var matchers []func(buildflags.Package) bool
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matchers = append(matchers, buildflags.MatchPattern(dir, pattern))
+	}
+	return func(pkg buildflags.Package) bool {
+		for _, match := range matchers {
+			if match(pkg) {
+				return true
+			}
+		}
+		return false
+	}
+}
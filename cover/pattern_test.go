@@ -0,0 +1,42 @@
+package cover
+
+import (
+	"testing"
+
+	"github.com/hpidcock/gophertest/buildflags"
+)
+
+func TestMatchPackages(t *testing.T) {
+	test := buildflags.Package{ImportPath: "github.com/x/y", Dir: "/src/y", Test: true}
+	dep := buildflags.Package{ImportPath: "github.com/x/y/z", Dir: "/src/y/z"}
+	other := buildflags.Package{ImportPath: "github.com/w", Dir: "/src/w"}
+	std := buildflags.Package{ImportPath: "fmt", Dir: "/goroot/src/fmt", Standard: true}
+
+	tests := []struct {
+		patterns []string
+		pkg      buildflags.Package
+		want     bool
+	}{
+		{nil, test, false},
+		{[]string{"github.com/x/y"}, test, true},
+		{[]string{"github.com/x/y"}, dep, false},
+		{[]string{"github.com/x/..."}, dep, true},
+		{[]string{"github.com/w", " github.com/x/y/z"}, dep, true},
+		{[]string{"all"}, other, true},
+		{[]string{"all"}, std, true},
+		{[]string{"std"}, std, true},
+		{[]string{"std"}, other, false},
+		{[]string{"./..."}, test, true},
+		{[]string{"./..."}, dep, true},
+		{[]string{"./..."}, other, false},
+		{[]string{"./z"}, dep, true},
+		{[]string{"../w"}, other, true},
+		{[]string{""}, test, false},
+	}
+	for _, tt := range tests {
+		match := MatchPackages("/src/y", tt.patterns)
+		if got := match(tt.pkg); got != tt.want {
+			t.Errorf("MatchPackages(%q)(%q) = %t, want %t", tt.patterns, tt.pkg.ImportPath, got, tt.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -88,9 +89,14 @@ func (d *DAG) Add(pkg *packages.Package, includeTests bool) (*Node, error) {
 		Name:      pkg.Name,
 		SourceDir: pkg.Dir,
 		RootDir:   pkg.Root,
+		TrimDir:   trimDir(pkg),
 		Goroot:    pkg.Goroot,
 		Standard:  pkg.Standard,
 		ImportMap: pkg.ImportMap,
+
+		CgoCPPFLAGS: pkg.CgoCPPFLAGS,
+		CgoCFLAGS:   pkg.CgoCFLAGS,
+		CgoLDFLAGS:  pkg.CgoLDFLAGS,
 	}
 	switch importPath {
 	case "C", "unsafe":
@@ -124,6 +130,41 @@ func (d *DAG) Add(pkg *packages.Package, includeTests bool) (*Node, error) {
 		}
 		bits.SFiles = append(bits.SFiles, sFile)
 	}
+	for _, f := range pkg.SysoFiles {
+		sysoFile := SysoFile{
+			Dir:      pkg.Dir,
+			Filename: f,
+		}
+		bits.SysoFiles = append(bits.SysoFiles, sysoFile)
+	}
+	for _, f := range pkg.CgoFiles {
+		goFile := GoFile{
+			Dir:      pkg.Dir,
+			Filename: f,
+		}
+		bits.CgoFiles = append(bits.CgoFiles, goFile)
+	}
+	for _, f := range pkg.CFiles {
+		cFile := CFile{
+			Dir:      pkg.Dir,
+			Filename: f,
+		}
+		bits.CFiles = append(bits.CFiles, cFile)
+	}
+	for _, f := range pkg.HFiles {
+		hFile := HFile{
+			Dir:      pkg.Dir,
+			Filename: f,
+		}
+		bits.HFiles = append(bits.HFiles, hFile)
+	}
+	bits.EmbedPatterns = append(bits.EmbedPatterns, pkg.EmbedPatterns...)
+	embedFiles := pkg.EmbedFiles
+	if includeTests {
+		bits.EmbedPatterns = append(bits.EmbedPatterns, pkg.TestEmbedPatterns...)
+		embedFiles = append(append([]string(nil), embedFiles...), pkg.TestEmbedFiles...)
+	}
+	bits.EmbedFiles = embedFilesIn(pkg.Dir, embedFiles)
 
 	alreadyImported := map[string]struct{}{}
 	if includeTests && bits.Tests {
@@ -144,10 +185,15 @@ func (d *DAG) Add(pkg *packages.Package, includeTests bool) (*Node, error) {
 			})
 		}
 	}
-	for _, imported := range pkg.Imports {
+	imports := append(append([]string(nil), pkg.Imports...), pkg.CgoImports()...)
+	for _, imported := range imports {
 		if _, ok := alreadyImported[imported]; ok {
 			continue
 		}
+		if imported == "C" {
+			// Not a package, cgo replaces it with CgoImports.
+			continue
+		}
 		alreadyImported[imported] = struct{}{}
 		importedNode := d.Obtain(imported)
 		importedNode.Deps = append(importedNode.Deps, node)
@@ -174,6 +220,7 @@ func (d *DAG) Add(pkg *packages.Package, includeTests bool) (*Node, error) {
 			Name:      pkg.Name + "_test",
 			SourceDir: pkg.Dir,
 			RootDir:   pkg.Root,
+			TrimDir:   trimDir(pkg),
 			Goroot:    pkg.Goroot,
 			Standard:  pkg.Standard,
 			Tests:     true,
@@ -189,6 +236,8 @@ func (d *DAG) Add(pkg *packages.Package, includeTests bool) (*Node, error) {
 			}
 			bitsX.GoFiles = append(bitsX.GoFiles, goFile)
 		}
+		bitsX.EmbedPatterns = pkg.XTestEmbedPatterns
+		bitsX.EmbedFiles = embedFilesIn(pkg.Dir, pkg.XTestEmbedFiles)
 
 		alreadyImportedX := map[string]struct{}{}
 		for _, imported := range pkg.XTestImports {
@@ -245,6 +294,34 @@ func (d *DAG) Add(pkg *packages.Package, includeTests bool) (*Node, error) {
 	return node, nil
 }
 
+// trimDir is what the go command rewrites the package directory to with
+// -trimpath: the module path and version followed by the package path within
+// the module, or the import path when the module has no version.
+func trimDir(pkg *packages.Package) string {
+	if pkg.Module.Version != "" {
+		return pkg.Module.Path + "@" + pkg.Module.Version + strings.TrimPrefix(pkg.ImportPath, pkg.Module.Path)
+	}
+	return pkg.ImportPath
+}
+
+// embedFilesIn returns the embedded files of a package in dir, files embedded
+// by both the package and its tests are listed once.
+func embedFilesIn(dir string, filenames []string) []EmbedFile {
+	var embedFiles []EmbedFile
+	seen := map[string]struct{}{}
+	for _, f := range filenames {
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		embedFiles = append(embedFiles, EmbedFile{
+			Dir:      dir,
+			Filename: f,
+		})
+	}
+	return embedFiles
+}
+
 // Obtain a Node by finding it or creating it and lock it.
 // Callers of Obtain must release the lock.
 func (d *DAG) Obtain(importPath string) *Node {
@@ -28,13 +28,31 @@ type NodeBits struct {
 	Tests     bool
 	SourceDir string
 	RootDir   string
+	// TrimDir replaces SourceDir in the file names recorded by a -trimpath
+	// build.
+	TrimDir   string
 	Goroot    bool
 	Standard  bool
 	Intrinsic bool
 	GoFiles   []GoFile
 	SFiles    []SFile
-	Imports   []Import
-	ImportMap map[string]string
+	SysoFiles []SysoFile
+	// CgoFiles import "C" and are translated by cgo before compiling.
+	CgoFiles    []GoFile
+	CFiles      []CFile
+	HFiles      []HFile
+	CgoCPPFLAGS []string
+	CgoCFLAGS   []string
+	CgoLDFLAGS  []string
+	// EmbedPatterns are the //go:embed patterns matching EmbedFiles.
+	EmbedPatterns []string
+	EmbedFiles    []EmbedFile
+	Imports       []Import
+	ImportMap     map[string]string
+	// CoverMode is set when the package is instrumented for coverage.
+	CoverMode string
+	// GcFlags are passed to the compiler for this package.
+	GcFlags []string
 
 	Shlib string
 	Meta  []interface{}
@@ -50,6 +68,8 @@ type GoFile struct {
 	Filename  string
 	Test      bool
 	Generator Generator
+	// CoverVar names the coverage counters added to the file.
+	CoverVar string
 }
 
 type SFile struct {
@@ -57,6 +77,27 @@ type SFile struct {
 	Filename string
 }
 
+type SysoFile struct {
+	Dir      string
+	Filename string
+}
+
+type CFile struct {
+	Dir      string
+	Filename string
+}
+
+type HFile struct {
+	Dir      string
+	Filename string
+}
+
+type EmbedFile struct {
+	Dir string
+	// Filename is relative to Dir and may be in a subdirectory.
+	Filename string
+}
+
 type Generator interface {
 	Generate(context.Context, *Node, GoFile, io.WriteCloser) error
 }
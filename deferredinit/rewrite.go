@@ -25,6 +25,7 @@ import (
 	"github.com/go-toolsmith/astcopy"
 	"github.com/gophertest/build"
 	"github.com/hpidcock/gophertest/dag"
+	"github.com/hpidcock/gophertest/util"
 	"github.com/pkg/errors"
 )
 
@@ -96,8 +97,10 @@ func (d *DeferredIniter) LoadPackages() error {
 			packages.NeedDeps |
 			packages.NeedSyntax |
 			packages.NeedName,
-		Tests: true,
-		Dir:   d.SourceDir,
+		Tests:      true,
+		Dir:        d.SourceDir,
+		BuildFlags: util.BuildFlags(d.BuildCtx),
+		Env:        util.BuildEnv(d.BuildCtx),
 	}
 
 	pkgs, err := packages.Load(config, importPaths...)
@@ -0,0 +1,21 @@
+package linker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defineImportPath returns the import path of the package a define sets a
+// variable in.
+func defineImportPath(define string) (string, error) {
+	i := strings.Index(define, "=")
+	if i < 0 {
+		return "", fmt.Errorf("-X %q: missing =value", define)
+	}
+	symbol := define[:i]
+	dot := strings.LastIndex(symbol, ".")
+	if dot <= strings.LastIndex(symbol, "/") || dot == len(symbol)-1 {
+		return "", fmt.Errorf("-X %q: expected importpath.name=value", define)
+	}
+	return symbol[:dot], nil
+}
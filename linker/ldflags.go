@@ -0,0 +1,91 @@
+package linker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gophertest/build"
+)
+
+// setLdFlags sets the link arguments for each of the ldflags the tools have a
+// field for. -X defines and -L paths are appended, and -extldflags are
+// appended to any already set from CC. The flags without a field, such as -s
+// and -w, are returned.
+func setLdFlags(args *build.LinkArgs, ldflags []string) ([]string, error) {
+	boolFlags := map[string]*bool{
+		"f":          &args.IgnoreVersionMismatch,
+		"g":          &args.DisableGoPackageDataChecks,
+		"h":          &args.HaltOnError,
+		"linkshared": &args.LinkShared,
+		"msan":       &args.MSan,
+		"race":       &args.Race,
+		"u":          &args.RejectUnsafePackages,
+	}
+	stringFlags := map[string]*string{
+		"E":             &args.EntrySymbolName,
+		"H":             &args.HeaderType,
+		"I":             &args.ELFDynamicLinker,
+		"buildid":       &args.BuildID,
+		"extar":         &args.ExternalTar,
+		"extld":         &args.ExternalLinker,
+		"installsuffix": &args.InstallSuffix,
+		"k":             &args.FieldTrackingSymbol,
+		"libgcc":        &args.LibGCC,
+		"linkmode":      &args.LinkMode,
+		"pluginpath":    &args.PluginPath,
+		"tmpdir":        &args.TempDir,
+	}
+	rest := []string(nil)
+	for i := 0; i < len(ldflags); i++ {
+		arg := ldflags[i]
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if name == arg {
+			rest = append(rest, arg)
+			continue
+		}
+		value := ""
+		hasValue := false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		switch name {
+		case "o", "importcfg", "buildmode":
+			return nil, fmt.Errorf("-%s is set by gophertest", name)
+		}
+		if field, ok := boolFlags[name]; ok {
+			*field = true
+			if hasValue {
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("-%s: invalid value %q", name, value)
+				}
+				*field = b
+			}
+			continue
+		}
+		field, ok := stringFlags[name]
+		if !ok && name != "X" && name != "L" && name != "extldflags" {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			i++
+			if i == len(ldflags) {
+				return nil, fmt.Errorf("-%s requires an argument", name)
+			}
+			value = ldflags[i]
+		}
+		switch name {
+		case "X":
+			args.StringDefines = append(args.StringDefines, value)
+		case "L":
+			args.LibraryPaths = append(args.LibraryPaths, value)
+		case "extldflags":
+			args.ExternalLinkerFlags = strings.TrimSpace(args.ExternalLinkerFlags + " " + value)
+		default:
+			*field = value
+		}
+	}
+	return rest, nil
+}
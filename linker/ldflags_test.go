@@ -0,0 +1,63 @@
+package linker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gophertest/build"
+)
+
+func TestSetLdFlags(t *testing.T) {
+	tests := []struct {
+		ldflags  []string
+		want     build.LinkArgs
+		wantRest []string
+	}{
+		{nil, build.LinkArgs{ExternalLinkerFlags: "-m64"}, nil},
+		{[]string{"-s", "-w"}, build.LinkArgs{ExternalLinkerFlags: "-m64"}, []string{"-s", "-w"}},
+		{[]string{"-X", "main.v=1"}, build.LinkArgs{ExternalLinkerFlags: "-m64", StringDefines: []string{"main.v=1"}}, nil},
+		{[]string{"--X", "main.v=1"}, build.LinkArgs{ExternalLinkerFlags: "-m64", StringDefines: []string{"main.v=1"}}, nil},
+		{[]string{"-X=main.v=1"}, build.LinkArgs{ExternalLinkerFlags: "-m64", StringDefines: []string{"main.v=1"}}, nil},
+		{
+			[]string{"-s", "-X", "main.a=1", "-w", "-X=main.b=a b"},
+			build.LinkArgs{ExternalLinkerFlags: "-m64", StringDefines: []string{"main.a=1", "main.b=a b"}},
+			[]string{"-s", "-w"},
+		},
+		{[]string{"-extldflags", "-static -lm"}, build.LinkArgs{ExternalLinkerFlags: "-m64 -static -lm"}, nil},
+		{[]string{"-extldflags=-static", "-extldflags=-lm"}, build.LinkArgs{ExternalLinkerFlags: "-m64 -static -lm"}, nil},
+		{
+			[]string{"-linkmode", "external", "-extld=clang", "-buildid", "x", "-L", "/a", "-L=/b"},
+			build.LinkArgs{ExternalLinkerFlags: "-m64", LinkMode: "external", ExternalLinker: "clang", BuildID: "x", LibraryPaths: []string{"/a", "/b"}},
+			nil,
+		},
+		{[]string{"-race", "-f=true", "-u=false"}, build.LinkArgs{ExternalLinkerFlags: "-m64", Race: true, IgnoreVersionMismatch: true}, nil},
+		{[]string{"-compressdwarf=false", "-r", "/lib"}, build.LinkArgs{ExternalLinkerFlags: "-m64"}, []string{"-compressdwarf=false", "-r", "/lib"}},
+		{[]string{"X"}, build.LinkArgs{ExternalLinkerFlags: "-m64"}, []string{"X"}},
+	}
+	for _, test := range tests {
+		args := build.LinkArgs{ExternalLinkerFlags: "-m64"}
+		rest, err := setLdFlags(&args, test.ldflags)
+		if err != nil {
+			t.Errorf("setLdFlags(%q): %v", test.ldflags, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.want) || !reflect.DeepEqual(rest, test.wantRest) {
+			t.Errorf("setLdFlags(%q) = %+v, %q, want %+v, %q", test.ldflags, args, rest, test.want, test.wantRest)
+		}
+	}
+}
+
+func TestSetLdFlagsErrors(t *testing.T) {
+	for _, ldflags := range [][]string{
+		{"-s", "-X"},
+		{"-extldflags"},
+		{"-race=maybe"},
+		{"-o", "out"},
+		{"-importcfg=cfg"},
+		{"-buildmode=pie"},
+	} {
+		if _, err := setLdFlags(&build.LinkArgs{}, ldflags); err == nil {
+			t.Errorf("setLdFlags(%q) succeeded, want error", ldflags)
+		}
+	}
+}
@@ -8,10 +8,12 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"sync"
 
 	"github.com/gophertest/build"
 	"github.com/hpidcock/gophertest/dag"
+	"github.com/hpidcock/gophertest/util"
 	"github.com/pkg/errors"
 )
 
@@ -26,6 +28,13 @@ type Linker struct {
 
 	WorkDir string
 	OutFile string
+	// LdFlags are passed to the linker. Any -X defines must set variables in
+	// linked packages and -extldflags are added to the flags from CC.
+	LdFlags []string
+	// CC is the C compiler used as the external linker.
+	CC []string
+	// TrimPath leaves GOROOT out of the binary, as go build -trimpath does.
+	TrimPath bool
 
 	packageMapMutex sync.Mutex
 	packageMap      map[string]string
@@ -81,20 +90,43 @@ func (l *Linker) Visit(ctx context.Context, node *dag.Node) error {
 		return errors.WithStack(err)
 	}
 
+	externalLinker := []string{"gcc"}
+	if len(l.CC) > 0 {
+		externalLinker = l.CC
+	}
+
 	out := &bytes.Buffer{}
 	args := build.LinkArgs{
-		WorkingDirectory: exeDir,
-		Stdout:           out,
-		Stderr:           out,
-		BuildMode:        "exe",
-		ExternalLinker:   "gcc",
-		ImportConfigFile: importConfigFile,
-		OutputFile:       l.OutFile,
-		Files:            []string{node.Shlib},
-		StringDefines: []string{
-			"runtime/internal/sys.DefaultGoroot=" + l.BuildCtx.GOROOT,
-		},
+		WorkingDirectory:    exeDir,
+		Stdout:              out,
+		Stderr:              out,
+		BuildMode:           "exe",
+		ExternalLinker:      externalLinker[0],
+		ExternalLinkerFlags: strings.Join(externalLinker[1:], " "),
+		ImportConfigFile:    importConfigFile,
+		OutputFile:          l.OutFile,
+		Race:                util.RaceEnabled(l.BuildCtx),
+	}
+	ldflags, err := setLdFlags(&args, l.LdFlags)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, define := range args.StringDefines {
+		importPath, err := defineImportPath(define)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, ok := l.packageMap[importPath]; !ok {
+			return fmt.Errorf("-X %q: package %q is not linked", define, importPath)
+		}
+	}
+	if !l.TrimPath {
+		// Defines from -ldflags come after, so they still win.
+		args.StringDefines = append([]string{"runtime/internal/sys.DefaultGoroot=" + l.BuildCtx.GOROOT}, args.StringDefines...)
 	}
+	// The tools have no field for the remaining flags, they are placed before
+	// the files instead.
+	args.Files = append(ldflags, node.Shlib)
 	err = l.Tools.Link(args)
 	if err != nil {
 		fmt.Fprint(os.Stderr, out)
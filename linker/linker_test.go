@@ -0,0 +1,131 @@
+package linker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"debug/elf"
+	gobuild "go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gophertest/build"
+	"github.com/hpidcock/gophertest/dag"
+)
+
+// TestLinkLdFlags links a program with flags the tools have no field for, a
+// define and external linker flags added to those from CC.
+func TestLinkLdFlags(t *testing.T) {
+	if gobuild.Default.GOOS != "linux" {
+		t.Skip("reads the ELF binary")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	dir, err := ioutil.TempDir("", "gophertest-linker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.go": "package main\n\nimport \"example.com/v\"\n\nfunc main() {\n\tprintln(v.Version)\n}\n",
+		"v.go":    "package v\n\nvar Version = \"unset\"\n",
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(path.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The external linker needs runtime/cgo.
+	l := &Linker{
+		BuildCtx: gobuild.Default,
+		Tools:    build.DefaultTools,
+		WorkDir:  dir,
+		OutFile:  path.Join(dir, "main"),
+		LdFlags:  []string{"-s", "-w", "-X", "example.com/v.Version=set", "-linkmode=external", "-extldflags", "-Wl,-rpath=/user"},
+		CC:       []string{"gcc", "-Wl,-rpath=/cc"},
+		TrimPath: true,
+	}
+	importConfig := path.Join(dir, "importcfg")
+	cmd := exec.Command("go", "list", "-export", "-deps", "-f", "{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}", "runtime", "runtime/cgo")
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=1", "GOFLAGS=", "GO111MODULE=off")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("go list: %v", err)
+	}
+	vArchive := path.Join(dir, "v.a")
+	out = append(out, "packagefile example.com/v="+vArchive+"\n"...)
+	err = ioutil.WriteFile(importConfig, out, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.packageMap = map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimPrefix(scanner.Text(), "packagefile ")
+		i := strings.Index(line, "=")
+		l.packageMap[line[:i]] = line[i+1:]
+	}
+	archive := path.Join(dir, "main.a")
+	for _, compile := range [][]string{
+		{"-p", "example.com/v", "-o", vArchive, path.Join(dir, "v.go")},
+		{"-p", "main", "-o", archive, path.Join(dir, "main.go")},
+	} {
+		args := append([]string{"tool", "compile", "-importcfg", importConfig}, compile...)
+		output, err := exec.Command("go", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+
+	err = l.Visit(context.Background(), &dag.Node{ImportPath: "main", NodeBits: &dag.NodeBits{Shlib: archive}})
+	if err != nil {
+		t.Fatalf("linking: %v", err)
+	}
+
+	stderr := &bytes.Buffer{}
+	cmd = exec.Command(l.OutFile)
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("running: %v\n%s", err, stderr)
+	}
+	if got := strings.TrimSpace(stderr.String()); got != "set" {
+		t.Errorf("program printed %q, want %q", got, "set")
+	}
+
+	f, err := elf.Open(l.OutFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, name := range []string{".symtab", ".debug_info"} {
+		if f.Section(name) != nil {
+			t.Errorf("binary has section %s, want it stripped", name)
+		}
+	}
+	rpath, err := f.DynString(elf.DT_RUNPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpath2, err := f.DynString(elf.DT_RPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpaths := strings.Join(append(rpath, rpath2...), ":")
+	for _, want := range []string{"/cc", "/user"} {
+		if !strings.Contains(rpaths, want) {
+			t.Errorf("binary run paths are %q, want %q from -extldflags and CC", rpaths, want)
+		}
+	}
+}
@@ -11,14 +11,18 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"strings"
 
 	"github.com/gophertest/build"
 	"github.com/pkg/errors"
 
 	"github.com/hpidcock/gophertest/builder"
+	"github.com/hpidcock/gophertest/buildflags"
+	"github.com/hpidcock/gophertest/cache"
 	"github.com/hpidcock/gophertest/cache/hasher"
 	"github.com/hpidcock/gophertest/cache/puller"
 	"github.com/hpidcock/gophertest/cache/storer"
+	"github.com/hpidcock/gophertest/cover"
 	"github.com/hpidcock/gophertest/dag"
 	"github.com/hpidcock/gophertest/deferredinit"
 	"github.com/hpidcock/gophertest/linker"
@@ -49,11 +53,32 @@ var (
 	flagLogBuild        = flag.Bool("x", false, "log build commands")
 	flagIgnoreCache     = flag.Bool("a", false, "force rebuilding")
 	flagSkipCacheUpdate = flag.Bool("u", false, "skip cache update")
+	flagCacheURL        = flag.String("cache-url", env("GOPHERTEST_CACHE_URL", ""), "share built packages through an HTTP cache, such as gophertest cache serve")
+	flagCacheMaxSize    = flag.String("cache-max-size", env("GOPHERTEST_CACHE_MAX_SIZE", defaultCacheMaxSize), "size to trim the package cache to, 0 is no limit")
+	flagCacheMaxAge     = flag.String("cache-max-age", env("GOPHERTEST_CACHE_MAX_AGE", defaultCacheMaxAge), "remove cached packages not used for this long, 0 is no limit")
 	flagVerbose         = flag.Bool("v", false, "verbose logging")
+	flagTags            = flag.String("tags", "", "comma separated list of build tags (default from GOFLAGS)")
+	flagRace            = flag.Bool("race", false, "build with the race detector")
+	flagTrimPath        = flag.Bool("trimpath", false, "remove file system paths from the build so cached packages are shared between checkouts")
+	flagCover           = flag.Bool("cover", false, "instrument packages for coverage")
+	flagCoverMode       = flag.String("covermode", "", "coverage mode: set, count or atomic (default set, atomic with -race)")
+	flagCoverPkg        = flag.String("coverpkg", "", "comma separated package patterns to instrument (default is the test packages)")
+	flagGcFlags         = &buildflags.PerPackage{}
+	flagLdFlags         = &buildflags.PerPackage{}
 )
 
+func init() {
+	flag.Var(flagGcFlags, "gcflags", "[pattern=]flags to pass to the compiler, may be repeated")
+	flag.Var(flagLdFlags, "ldflags", "[pattern=]flags to pass to the linker, may be repeated")
+}
+
 func main() {
-	err := Main()
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		err = CacheMain(os.Args[2:])
+	} else {
+		err = Main()
+	}
 	if err != nil {
 		fmt.Printf("%+v", err)
 	}
@@ -139,24 +164,136 @@ func Main() (errOut error) {
 		os.Exit(-1)
 	}
 
+	// As with the go command, flags override the same flags in GOFLAGS.
+	goFlags, err := util.ParseGOFLAGS(os.Getenv("GOFLAGS"))
+	if err != nil {
+		return errors.Wrap(err, "parsing GOFLAGS")
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tags":
+			goFlags.Tags = util.ParseTags(*flagTags)
+		case "race":
+			goFlags.Race = *flagRace
+		case "trimpath":
+			goFlags.TrimPath = *flagTrimPath
+		case "gcflags":
+			goFlags.GcFlags = nil
+		case "ldflags":
+			goFlags.LdFlags = nil
+		}
+	})
+	// Relative patterns are resolved from the package directory.
+	flagGcFlags.Dir = srcDir
+	flagLdFlags.Dir = srcDir
+	for _, value := range goFlags.GcFlags {
+		err = flagGcFlags.Set(value)
+		if err != nil {
+			return errors.Wrapf(err, "parsing -gcflags %q from GOFLAGS", value)
+		}
+	}
+	for _, value := range goFlags.LdFlags {
+		err = flagLdFlags.Set(value)
+		if err != nil {
+			return errors.Wrapf(err, "parsing -ldflags %q from GOFLAGS", value)
+		}
+	}
+
+	coverMode := ""
+	if *flagCover || *flagCoverPkg != "" {
+		coverMode = *flagCoverMode
+		if coverMode == "" && goFlags.Race {
+			coverMode = cover.ModeAtomic
+		} else if coverMode == "" {
+			coverMode = cover.ModeSet
+		}
+		if !cover.ValidMode(coverMode) {
+			fmt.Fprintf(os.Stderr, "invalid cover mode %q", coverMode)
+			os.Exit(-1)
+		}
+	}
+	limits, err := cacheLimits(*flagCacheMaxSize, *flagCacheMaxAge)
+	if err != nil {
+		return errors.Wrap(err, "parsing cache limits")
+	}
+
+	coverPatterns := testPackages
+	if *flagCoverPkg != "" {
+		coverPatterns = strings.Split(*flagCoverPkg, ",")
+	}
+	coverPackage := cover.MatchPackages(srcDir, coverPatterns)
+
 	buildCtx, err = tools.BuildCtx()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	// For now we don't support cgo.
-	buildCtx.CgoEnabled = false
+	// The target may differ from the host, including when set with go env -w.
+	// go env also disables cgo by default when cross-compiling.
+	target, err := util.GoEnv(os.Environ(), "GOOS", "GOARCH", "CGO_ENABLED")
+	if err != nil {
+		return errors.Wrap(err, "reading target")
+	}
+	buildCtx.GOOS = target["GOOS"]
+	buildCtx.GOARCH = target["GOARCH"]
+	buildCtx.CgoEnabled = target["CGO_ENABLED"] == "1"
 	buildCtx.UseAllFiles = false
-	err = os.Setenv("CGO_ENABLED", "0")
+
+	// Release tags are those of the toolchain building the tests, not the one
+	// gophertest was built with.
+	buildCtx.ReleaseTags, err = util.ReleaseTags(buildCtx)
 	if err != nil {
-		return errors.WithStack(err)
+		return errors.Wrap(err, "reading release tags")
+	}
+
+	cgo := util.CgoConfig{}
+	if buildCtx.CgoEnabled {
+		cgo, err = util.LoadCgoConfig(buildCtx)
+		if err != nil {
+			return errors.Wrap(err, "reading cgo config")
+		}
+		if len(cgo.CC) == 0 {
+			return errors.New("cgo is enabled but CC is empty")
+		}
 	}
 
 	logger.Infof("GOARCH=%q", buildCtx.GOARCH)
 	logger.Infof("GOOS=%q", buildCtx.GOOS)
 	logger.Infof("GOROOT=%q", buildCtx.GOROOT)
 	logger.Infof("GOPATH=%q", buildCtx.GOPATH)
-	logger.Infof("CGO_ENABLED=0")
+	logger.Infof("CGO_ENABLED=%t", buildCtx.CgoEnabled)
+	logger.Infof("CC=%q", cgo.CC)
+
+	archEnv, err := util.ArchEnv(buildCtx)
+	if err != nil {
+		return errors.Wrap(err, "reading target instruction set")
+	}
+	if i := strings.Index(archEnv, "="); i >= 0 {
+		// The compiler reads the instruction set from the environment.
+		name, value := archEnv[:i], archEnv[i+1:]
+		err = os.Setenv(name, value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		logger.Infof("%s=%q", name, value)
+	}
+
+	buildCtx.BuildTags = append(buildCtx.BuildTags, goFlags.Tags...)
+	logger.Infof("tags=%q", goFlags.Tags)
+
+	if goFlags.Race {
+		// Outside of darwin the race runtime is linked with cgo.
+		if buildCtx.GOOS != "darwin" && !buildCtx.CgoEnabled {
+			fmt.Fprintf(os.Stderr, "-race requires cgo on %s/%s, set CGO_ENABLED=1", buildCtx.GOOS, buildCtx.GOARCH)
+			os.Exit(-1)
+		}
+		buildCtx.InstallSuffix = "race"
+		buildCtx.BuildTags = append(buildCtx.BuildTags, "race")
+		logger.Infof("race=true")
+	}
+	if goFlags.TrimPath {
+		logger.Infof("trimpath=true")
+	}
 
 	cacheDir, err = util.CacheDir(buildCtx)
 	if err != nil {
@@ -169,10 +306,25 @@ func Main() (errOut error) {
 	}
 	defer lock.Unlock()
 
+	pkgCache := cache.Cache(&cache.Dir{
+		Root: cacheDir,
+	})
+	if *flagCacheURL != "" {
+		logger.Infof("cache-url=%q", *flagCacheURL)
+		pkgCache = &cache.HTTP{
+			Logger: logger,
+			URL:    *flagCacheURL,
+			Dir:    remoteCacheDir(cacheDir),
+		}
+	}
+
 	runtime.GC()
 	logger.Infof("importing packages")
 	fullPackages := append([]string(nil), testPackages...)
 	fullPackages = append(fullPackages, runner.Deps...)
+	if util.RaceEnabled(buildCtx) {
+		fullPackages = append(fullPackages, "runtime/race")
+	}
 	buildPkgs, err := packages.ImportAll(buildCtx, srcDir, fullPackages)
 
 	testPackagesMap := map[string]struct{}{}
@@ -185,10 +337,37 @@ func Main() (errOut error) {
 	d := dag.NewDAG(logger)
 	for _, pkg := range buildPkgs {
 		_, includeTests := testPackagesMap[pkg.ImportPath]
-		_, err := d.Add(pkg, includeTests)
+		flagPkg := buildflags.Package{
+			ImportPath: pkg.ImportPath,
+			Dir:        pkg.Dir,
+			Standard:   pkg.Standard,
+			Test:       includeTests,
+		}
+		// The standard library is never instrumented.
+		covered := coverMode != "" && !pkg.Standard && coverPackage(flagPkg)
+		if covered && coverMode == cover.ModeAtomic {
+			// Atomic counters are updated with sync/atomic.
+			pkg.Imports = append(pkg.Imports, "sync/atomic")
+		}
+		node, err := d.Add(pkg, includeTests)
 		if err != nil {
 			return errors.Wrapf(err, "adding %q to dag", pkg.ImportPath)
 		}
+		if covered {
+			node.Mutex.Lock()
+			cover.Mark(node, coverMode)
+			node.Mutex.Unlock()
+		}
+		gcFlags := flagGcFlags.For(flagPkg)
+		if len(gcFlags) > 0 {
+			node.Mutex.Lock()
+			node.GcFlags = gcFlags
+			node.Mutex.Unlock()
+			if nodeX := d.Find(pkg.ImportPath + "_test"); nodeX != nil {
+				nodeX.GcFlags = gcFlags
+				nodeX.Mutex.Unlock()
+			}
+		}
 	}
 
 	runtime.GC()
@@ -204,6 +383,9 @@ func Main() (errOut error) {
 		Logger:   logger,
 		BuildCtx: buildCtx,
 		Tools:    tools,
+		ArchEnv:  archEnv,
+		Cgo:      cgo,
+		TrimPath: goFlags.TrimPath,
 	})
 	if err != nil {
 		return errors.Wrap(err, "hashing source")
@@ -216,7 +398,7 @@ func Main() (errOut error) {
 			BuildCtx: buildCtx,
 			Tools:    tools,
 			WorkDir:  workDir,
-			CacheDir: cacheDir,
+			Cache:    pkgCache,
 		}
 		err = d.VisitAll(context.Background(), pull, runtime.NumCPU())
 		if err != nil {
@@ -252,6 +434,18 @@ func Main() (errOut error) {
 		return errors.Wrap(err, "rewriting tests")
 	}
 
+	if coverMode != "" {
+		logger.Infof("instrumenting packages for coverage")
+		err = d.VisitAllFromRight(context.Background(), &cover.Instrumenter{
+			Logger:   logger,
+			BuildCtx: buildCtx,
+			WorkDir:  workDir,
+		})
+		if err != nil {
+			return errors.Wrap(err, "instrumenting packages")
+		}
+	}
+
 	runtime.GC()
 	logger.Infof("validating dag")
 	err = d.CheckComplete()
@@ -259,11 +453,21 @@ func Main() (errOut error) {
 		return errors.Wrap(err, "dag incomplete")
 	}
 
+	// The combined binary links as a test package would.
+	ldFlags := flagLdFlags.For(buildflags.Package{
+		ImportPath: "main",
+		Test:       true,
+	})
+
 	gen := &maingen.Generator{
 		Logger:   logger,
 		BuildCtx: buildCtx,
 		Tools:    tools,
 		WorkDir:  workDir,
+		LdFlags:  ldFlags,
+		ArchEnv:  archEnv,
+		Cgo:      cgo,
+		TrimPath: goFlags.TrimPath,
 	}
 
 	runtime.GC()
@@ -286,7 +490,7 @@ func Main() (errOut error) {
 				Logger:   logger,
 				BuildCtx: buildCtx,
 				Tools:    tools,
-				CacheDir: cacheDir,
+				Cache:    pkgCache,
 			}
 			logger.Infof("storing build result in cache")
 			err = d.VisitAll(context.Background(), storer, runtime.NumCPU())
@@ -299,6 +503,12 @@ func Main() (errOut error) {
 				}
 				return
 			}
+
+			err = trimCache(logger, cacheDir, limits, false)
+			if err != nil {
+				// The build succeeded, the cache is trimmed next time.
+				fmt.Fprintf(os.Stderr, "failed trimming cache: %v\n", err)
+			}
 		}
 
 		if !*flagKeepWorkDir {
@@ -319,10 +529,13 @@ func Main() (errOut error) {
 	runtime.GC()
 	logger.Infof("building packages")
 	err = d.VisitAllFromRight(context.Background(), &builder.Builder{
-		Logger:   logger,
-		BuildCtx: buildCtx,
-		Tools:    tools,
-		WorkDir:  workDir,
+		Logger:     logger,
+		BuildCtx:   buildCtx,
+		Tools:      tools,
+		WorkDir:    workDir,
+		AsmDefines: util.AsmDefines(buildCtx, archEnv),
+		Cgo:        cgo,
+		TrimPath:   goFlags.TrimPath,
 	})
 	if err != nil {
 		return errors.Wrap(err, "compiling")
@@ -336,6 +549,9 @@ func Main() (errOut error) {
 		Tools:    tools,
 		WorkDir:  workDir,
 		OutFile:  outFile,
+		CC:       cgo.CC,
+		TrimPath: goFlags.TrimPath,
+		LdFlags:  ldFlags,
 	})
 	if err != nil {
 		return errors.Wrap(err, "linking")
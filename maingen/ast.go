@@ -75,6 +75,41 @@ func isBenchmark(fd *ast.FuncDecl) bool {
 	return false
 }
 
+func isFuzz(fd *ast.FuncDecl) bool {
+	if !strings.HasPrefix(fd.Name.String(), "Fuzz") {
+		return false
+	}
+	if fd.Recv != nil {
+		return false
+	}
+	if fd.Type.Results != nil {
+		return false
+	}
+	params := fd.Type.Params.List
+	if len(params) != 1 {
+		return false
+	}
+	first := params[0]
+	if len(first.Names) > 1 {
+		return false
+	}
+	argExp, ok := first.Type.(*ast.StarExpr)
+	if ok == false {
+		return false
+	}
+	switch argType := argExp.X.(type) {
+	case *ast.SelectorExpr:
+		if argType.Sel.Name == "F" {
+			return true
+		}
+	case *ast.Ident:
+		if argType.Name == "F" {
+			return true
+		}
+	}
+	return false
+}
+
 func isTestMain(fd *ast.FuncDecl) bool {
 	if fd.Name.String() != "TestMain" {
 		return false
@@ -131,3 +166,25 @@ func isGopherTestInit(fd *ast.FuncDecl) bool {
 	}
 	return true
 }
+
+func isExample(fd *ast.FuncDecl) bool {
+	if !strings.HasPrefix(fd.Name.String(), "Example") {
+		return false
+	}
+	if fd.Recv != nil {
+		return false
+	}
+	if fd.Type.Results != nil {
+		params := fd.Type.Results.List
+		if len(params) != 0 {
+			return false
+		}
+	}
+	if fd.Type.Params != nil {
+		params := fd.Type.Params.List
+		if len(params) != 0 {
+			return false
+		}
+	}
+	return true
+}
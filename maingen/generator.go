@@ -2,9 +2,12 @@ package maingen
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"go/ast"
 	gobuild "go/build"
+	"go/doc"
 	"go/parser"
 	"go/token"
 	"io"
@@ -13,6 +16,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"text/template"
 
 	"github.com/hpidcock/gophertest/cache/hasher"
 
@@ -20,6 +24,7 @@ import (
 	"github.com/hpidcock/gophertest/dag"
 	"github.com/hpidcock/gophertest/maingen/runner"
 	"github.com/hpidcock/gophertest/packages"
+	"github.com/hpidcock/gophertest/util"
 	"github.com/pkg/errors"
 )
 
@@ -33,18 +38,44 @@ type Generator struct {
 	Tools    build.Tools
 
 	WorkDir string
+	// LdFlags are passed to the linker, -X defines can change the results of
+	// tests so they are part of the key of cached results.
+	LdFlags []string
+	// ArchEnv, Cgo and TrimPath hash the main package as the other packages
+	// are, see hasher.Hasher.
+	ArchEnv  string
+	Cgo      util.CgoConfig
+	TrimPath bool
 
 	testPackagesMutex sync.Mutex
 	testPackages      map[string]*testPackage
+	coverPackages     []coverPackage
 }
 
 func (g *Generator) FindTests(ctx context.Context, node *dag.Node) error {
+	g.testPackagesMutex.Lock()
+	defer g.testPackagesMutex.Unlock()
+
+	if node.CoverMode != "" {
+		pkg := coverPackage{
+			ImportPath: node.ImportPath,
+			Mode:       node.CoverMode,
+		}
+		for _, goFile := range node.GoFiles {
+			if goFile.CoverVar == "" {
+				continue
+			}
+			pkg.Files = append(pkg.Files, coverFile{
+				Name: path.Join(node.ImportPath, goFile.Filename),
+				Var:  goFile.CoverVar,
+			})
+		}
+		g.coverPackages = append(g.coverPackages, pkg)
+	}
+
 	if !node.Tests {
 		return nil
 	}
-
-	g.testPackagesMutex.Lock()
-	defer g.testPackagesMutex.Unlock()
 	if g.testPackages == nil {
 		g.testPackages = make(map[string]*testPackage)
 	}
@@ -80,6 +111,14 @@ func (g *Generator) FindTests(ctx context.Context, node *dag.Node) error {
 		if err != nil {
 			return err
 		}
+		// Examples without output comments are compiled but not run.
+		examples := map[string]*doc.Example{}
+		for _, ex := range doc.Examples(tree) {
+			if ex.Output == "" && !ex.EmptyOutput {
+				continue
+			}
+			examples["Example"+ex.Name] = ex
+		}
 		for _, decl := range tree.Decls {
 			fd, ok := decl.(*ast.FuncDecl)
 			if ok == false {
@@ -90,6 +129,14 @@ func (g *Generator) FindTests(ctx context.Context, node *dag.Node) error {
 				pkg.Tests = append(pkg.Tests, test{node.ImportPath, fd.Name.String()})
 			case isBenchmark(fd):
 				pkg.Benchmarks = append(pkg.Benchmarks, benchmark{node.ImportPath, fd.Name.String()})
+			case isFuzz(fd):
+				pkg.Fuzz = append(pkg.Fuzz, fuzz{node.ImportPath, fd.Name.String()})
+			case isExample(fd):
+				ex, ok := examples[fd.Name.String()]
+				if !ok {
+					continue
+				}
+				pkg.Examples = append(pkg.Examples, example{node.ImportPath, fd.Name.String(), ex.Output, ex.Unordered})
 			case isTestMain(fd):
 				if pkg.TestMain != "" {
 					return fmt.Errorf("ambigious TestMain in %q", node.ImportPath)
@@ -111,14 +158,114 @@ func (g *Generator) GenerateMain(ctx context.Context, d *dag.DAG) error {
 	g.testPackagesMutex.Lock()
 	defer g.testPackagesMutex.Unlock()
 
+	runnerCtx := g.runnerContext()
+
+	srcDir := path.Join(g.WorkDir, "main")
+	err := os.Mkdir(srcDir, 0777)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	rawImports := []string{}
+	for _, pkg := range g.testPackages {
+		if pkg.Test != nil {
+			rawImports = append(rawImports, pkg.Test.ImportPath)
+		}
+		if pkg.XTest != nil {
+			rawImports = append(rawImports, pkg.XTest.ImportPath)
+		}
+	}
+	for _, pkg := range g.coverPackages {
+		rawImports = append(rawImports, pkg.ImportPath)
+	}
+	rawImports = append(rawImports, runner.Deps...)
+	if util.RaceEnabled(g.BuildCtx) {
+		// The linker loads the race runtime itself.
+		rawImports = append(rawImports, "runtime/race")
+	}
+
+	pkg := &packages.Package{
+		ImportPath: "main",
+		Name:       "main",
+		Dir:        srcDir,
+		Imports:    rawImports,
+	}
+	node, err := d.Add(pkg, false)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	node.Mutex.Lock()
+	defer node.Mutex.Unlock()
+
+	node.GoFiles = append(node.GoFiles, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "main.go",
+		Generator: &mainGoGenerator{Context: runnerCtx, ldFlags: g.LdFlags},
+	}, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "json.go",
+		Generator: &supportGoGenerator{runner.JSONTemplate},
+	}, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "junit.go",
+		Generator: &supportGoGenerator{runner.JUnitTemplate},
+	}, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "cover.go",
+		Generator: &supportGoGenerator{runner.CoverTemplate},
+	}, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "results.go",
+		Generator: &supportGoGenerator{runner.ResultsTemplate},
+	}, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "shard.go",
+		Generator: &supportGoGenerator{runner.ShardTemplate},
+	}, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "history.go",
+		Generator: &supportGoGenerator{runner.HistoryTemplate},
+	}, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "output.go",
+		Generator: &supportGoGenerator{runner.OutputTemplate},
+	}, dag.GoFile{
+		Dir:       srcDir,
+		Filename:  "proc.go",
+		Generator: &supportGoGenerator{runner.ProcTemplate(g.BuildCtx.GOOS)},
+	})
+
+	// TODO: Fix dependency
+	hasher := &hasher.Hasher{
+		Logger:   g.Logger,
+		BuildCtx: g.BuildCtx,
+		Tools:    g.Tools,
+		ArchEnv:  g.ArchEnv,
+		Cgo:      g.Cgo,
+		TrimPath: g.TrimPath,
+	}
+	err = hasher.Visit(ctx, node)
+	if err != nil {
+		return errors.Wrap(err, "hashing main")
+	}
+
+	return nil
+}
+
+// runnerContext lists the tests, benchmarks, fuzz targets and examples found
+// in each test package, and the packages instrumented for coverage, for the
+// runner template.
+func (g *Generator) runnerContext() runner.Context {
 	id := -1
 	nextID := func() string {
 		id++
 		return fmt.Sprintf("pkg%d", id)
 	}
-	runnerCtx := runner.Context{}
+	runnerCtx := runner.Context{
+		Fuzz: util.HasReleaseTag(g.BuildCtx, "go1.18"),
+	}
 	for _, pkg := range g.testPackages {
-		if len(pkg.Tests) == 0 && len(pkg.Benchmarks) == 0 {
+		if len(pkg.Tests) == 0 && len(pkg.Benchmarks) == 0 && len(pkg.Examples) == 0 && len(pkg.Fuzz) == 0 {
 			continue
 		}
 		t := runner.Target{
@@ -183,6 +330,48 @@ func (g *Generator) GenerateMain(ctx context.Context, d *dag.DAG) error {
 				})
 			}
 		}
+		fuzzFuncs := pkg.Fuzz
+		if !runnerCtx.Fuzz {
+			// The testing package cannot run them, so the test packages are
+			// not imported for them.
+			fuzzFuncs = nil
+		}
+		for _, fuzzFunc := range fuzzFuncs {
+			switch {
+			case pkg.Test != nil && pkg.Test.ImportPath == fuzzFunc.ImportPath:
+				t.ImportTest = true
+				t.FuzzTargets = append(t.FuzzTargets, runner.Test{
+					Package: t.TestName,
+					Name:    fuzzFunc.Name,
+				})
+			case pkg.XTest != nil && pkg.XTest.ImportPath == fuzzFunc.ImportPath:
+				t.ImportXTest = true
+				t.FuzzTargets = append(t.FuzzTargets, runner.Test{
+					Package: t.XTestName,
+					Name:    fuzzFunc.Name,
+				})
+			}
+		}
+		for _, exampleFunc := range pkg.Examples {
+			switch {
+			case pkg.Test != nil && pkg.Test.ImportPath == exampleFunc.ImportPath:
+				t.ImportTest = true
+				t.Examples = append(t.Examples, runner.Example{
+					Package:   t.TestName,
+					Name:      exampleFunc.Name,
+					Output:    exampleFunc.Output,
+					Unordered: exampleFunc.Unordered,
+				})
+			case pkg.XTest != nil && pkg.XTest.ImportPath == exampleFunc.ImportPath:
+				t.ImportXTest = true
+				t.Examples = append(t.Examples, runner.Example{
+					Package:   t.XTestName,
+					Name:      exampleFunc.Name,
+					Output:    exampleFunc.Output,
+					Unordered: exampleFunc.Unordered,
+				})
+			}
+		}
 		if t.ImportTest == false && pkg.Test != nil {
 			t.ImportTest = true
 			t.TestName = "_"
@@ -198,61 +387,57 @@ func (g *Generator) GenerateMain(ctx context.Context, d *dag.DAG) error {
 		return runnerCtx.Targets[i].ImportPath < runnerCtx.Targets[j].ImportPath
 	})
 
-	srcDir := path.Join(g.WorkDir, "main")
-	err := os.Mkdir(srcDir, 0777)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	rawImports := []string{}
-	for _, pkg := range g.testPackages {
-		if pkg.Test != nil {
-			rawImports = append(rawImports, pkg.Test.ImportPath)
+	sort.Slice(g.coverPackages, func(i, j int) bool {
+		return g.coverPackages[i].ImportPath < g.coverPackages[j].ImportPath
+	})
+	for _, pkg := range g.coverPackages {
+		runnerCtx.CoverMode = pkg.Mode
+		c := runner.CoverPackage{
+			Package:    nextID(),
+			ImportPath: pkg.ImportPath,
 		}
-		if pkg.XTest != nil {
-			rawImports = append(rawImports, pkg.XTest.ImportPath)
+		for _, f := range pkg.Files {
+			c.Files = append(c.Files, runner.CoverFile{
+				Name: f.Name,
+				Var:  f.Var,
+			})
 		}
+		runnerCtx.Cover = append(runnerCtx.Cover, c)
 	}
-	rawImports = append(rawImports, runner.Deps...)
 
-	pkg := &packages.Package{
-		ImportPath: "main",
-		Name:       "main",
-		Dir:        srcDir,
-		Imports:    rawImports,
-	}
-	node, err := d.Add(pkg, false)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	node.Mutex.Lock()
-	defer node.Mutex.Unlock()
+	return runnerCtx
+}
 
-	node.GoFiles = append(node.GoFiles, dag.GoFile{
-		Dir:       srcDir,
-		Filename:  "main.go",
-		Generator: &mainGoGenerator{runnerCtx},
-	})
+type mainGoGenerator struct {
+	runner.Context
+	ldFlags []string
+}
 
-	// TODO: Fix dependency
-	hasher := &hasher.Hasher{
-		BuildCtx: g.BuildCtx,
-		Tools:    g.Tools,
+// linkID identifies how the test binary is linked: the build of the main
+// package, which covers every linked package, and the flags passed to the
+// linker.
+func (m *mainGoGenerator) linkID(node *dag.Node) (string, error) {
+	buildID := ""
+	for _, meta := range node.Meta {
+		switch v := meta.(type) {
+		case *hasher.HashMeta:
+			buildID = v.BuildID
+		}
 	}
-	err = hasher.Visit(ctx, node)
+	if buildID == "" {
+		return "", fmt.Errorf("missing build id for %q", node.ImportPath)
+	}
+	s := sha256.New()
+	_, err := fmt.Fprintf(s, "%s:%q", buildID, m.ldFlags)
 	if err != nil {
-		return errors.Wrap(err, "hashing main")
+		return "", errors.WithStack(err)
 	}
-
-	return nil
-}
-
-type mainGoGenerator struct {
-	runner.Context
+	return hex.EncodeToString(s.Sum(nil)), nil
 }
 
 func (m *mainGoGenerator) Generate(ctx context.Context, node *dag.Node, goFile dag.GoFile, writer io.WriteCloser) error {
 	importComplexity := map[string]int64{}
+	importBuildIDs := map[string]string{}
 	for _, imported := range node.Imports {
 		imported.Mutex.Lock()
 		if imported.Intrinsic {
@@ -260,6 +445,12 @@ func (m *mainGoGenerator) Generate(ctx context.Context, node *dag.Node, goFile d
 			continue
 		}
 		importPath := imported.ImportPath
+		for _, meta := range imported.Meta {
+			switch v := meta.(type) {
+			case *hasher.HashMeta:
+				importBuildIDs[importPath] = v.BuildID
+			}
+		}
 		stat, err := os.Stat(imported.Shlib)
 		imported.Mutex.Unlock()
 		if err != nil {
@@ -268,23 +459,62 @@ func (m *mainGoGenerator) Generate(ctx context.Context, node *dag.Node, goFile d
 		importComplexity[importPath] = stat.Size()
 	}
 
+	linkID, err := m.linkID(node)
+	if err != nil {
+		return errors.WithStack(err)
+	}
 	for k, v := range m.Targets {
+		// The build IDs of the test packages cover everything they depend
+		// on, so together with how the binary is linked they identify the
+		// build of the target.
+		buildIDs := []string{}
 		if v.ImportTest {
 			complexity, ok := importComplexity[v.ImportPath]
 			if ok {
 				v.TestComplexity += complexity
 			}
+			buildIDs = append(buildIDs, importBuildIDs[v.ImportPath])
 		}
 		if v.ImportXTest {
 			complexity, ok := importComplexity[v.ImportPath+"_test"]
 			if ok {
 				v.TestComplexity += complexity
 			}
+			buildIDs = append(buildIDs, importBuildIDs[v.ImportPath+"_test"])
+		}
+		for _, buildID := range buildIDs {
+			if buildID == "" {
+				buildIDs = nil
+				break
+			}
+		}
+		if buildIDs != nil {
+			v.BuildID = strings.Join(append(buildIDs, linkID), ":")
 		}
 		m.Targets[k] = v
 	}
 
-	err := runner.Template.Execute(writer, m.Context)
+	err = runner.Template.Execute(writer, m.Context)
+	if err != nil {
+		writer.Close()
+		return errors.WithStack(err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// supportGoGenerator writes runner code that does not depend on the targets.
+type supportGoGenerator struct {
+	template *template.Template
+}
+
+func (s *supportGoGenerator) Generate(ctx context.Context, node *dag.Node, goFile dag.GoFile, writer io.WriteCloser) error {
+	err := s.template.Execute(writer, nil)
 	if err != nil {
 		writer.Close()
 		return errors.WithStack(err)
@@ -0,0 +1,188 @@
+package maingen
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hpidcock/gophertest/dag"
+	"github.com/hpidcock/gophertest/maingen/runner"
+)
+
+const testSource = `package a
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestA(t *testing.T) {}
+
+func TestHelper(x int) {}
+
+func BenchmarkA(b *testing.B) {}
+
+func FuzzA(f *testing.F) {}
+
+func ExampleA() {
+	fmt.Println("a")
+	// Output: a
+}
+
+func ExampleNoOutput() {
+	fmt.Println("not run")
+}
+
+func ExampleUnordered() {
+	fmt.Println("b")
+	fmt.Println("a")
+	// Unordered output:
+	// b
+	// a
+}
+
+func ExampleEmpty() {
+	// Output:
+}
+
+func ExampleArgs(x int) {
+	// Output: x
+}
+`
+
+const xtestSource = `package a_test
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestX(t *testing.T) {}
+
+func FuzzX(f *testing.F) {}
+
+func Example() {
+	fmt.Println("x")
+	// Output: x
+}
+`
+
+// findTests runs FindTests over a package with the test sources above.
+func findTests(t *testing.T, g *Generator) {
+	dir, err := ioutil.TempDir("", "gophertest-maingen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.go":      "package a\n",
+		"a_test.go": testSource,
+		"x_test.go": xtestSource,
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodes := []*dag.Node{{
+		ImportPath: "example.com/a",
+		NodeBits: &dag.NodeBits{
+			Name:      "a",
+			Tests:     true,
+			SourceDir: dir,
+			GoFiles: []dag.GoFile{
+				{Dir: dir, Filename: "a.go"},
+				{Dir: dir, Filename: "a_test.go", Test: true},
+			},
+		},
+	}, {
+		ImportPath: "example.com/a_test",
+		NodeBits: &dag.NodeBits{
+			Name:      "a_test",
+			Tests:     true,
+			SourceDir: dir,
+			GoFiles: []dag.GoFile{
+				{Dir: dir, Filename: "x_test.go", Test: true},
+			},
+		},
+	}}
+	for _, node := range nodes {
+		err := g.FindTests(context.Background(), node)
+		if err != nil {
+			t.Fatalf("FindTests(%q): %v", node.ImportPath, err)
+		}
+	}
+}
+
+func TestFindTests(t *testing.T) {
+	g := &Generator{}
+	findTests(t, g)
+
+	pkg := g.testPackages["example.com/a"]
+	if pkg == nil {
+		t.Fatalf("missing test package, got %v", g.testPackages)
+	}
+	wantTests := []test{{"example.com/a", "TestA"}, {"example.com/a_test", "TestX"}}
+	if !reflect.DeepEqual(pkg.Tests, wantTests) {
+		t.Errorf("tests are %v, want %v", pkg.Tests, wantTests)
+	}
+	wantBenchmarks := []benchmark{{"example.com/a", "BenchmarkA"}}
+	if !reflect.DeepEqual(pkg.Benchmarks, wantBenchmarks) {
+		t.Errorf("benchmarks are %v, want %v", pkg.Benchmarks, wantBenchmarks)
+	}
+	wantFuzz := []fuzz{{"example.com/a", "FuzzA"}, {"example.com/a_test", "FuzzX"}}
+	if !reflect.DeepEqual(pkg.Fuzz, wantFuzz) {
+		t.Errorf("fuzz targets are %v, want %v", pkg.Fuzz, wantFuzz)
+	}
+	// Examples without an output comment are compiled but not run.
+	wantExamples := []example{
+		{"example.com/a", "ExampleA", "a\n", false},
+		{"example.com/a", "ExampleUnordered", "b\na\n", true},
+		{"example.com/a", "ExampleEmpty", "", false},
+		{"example.com/a_test", "Example", "x\n", false},
+	}
+	if !reflect.DeepEqual(pkg.Examples, wantExamples) {
+		t.Errorf("examples are %v, want %v", pkg.Examples, wantExamples)
+	}
+}
+
+func TestRunnerContextFuzz(t *testing.T) {
+	tests := []struct {
+		releaseTags []string
+		wantFuzz    []runner.Test
+	}{
+		{[]string{"go1.1", "go1.17"}, nil},
+		{[]string{"go1.1", "go1.17", "go1.18"}, []runner.Test{{Package: "pkg0", Name: "FuzzA"}, {Package: "pkg1", Name: "FuzzX"}}},
+	}
+	for _, test := range tests {
+		g := &Generator{}
+		g.BuildCtx.ReleaseTags = test.releaseTags
+		findTests(t, g)
+
+		ctx := g.runnerContext()
+		if len(ctx.Targets) != 1 {
+			t.Fatalf("got %d targets, want 1", len(ctx.Targets))
+		}
+		if !reflect.DeepEqual(ctx.Targets[0].FuzzTargets, test.wantFuzz) {
+			t.Errorf("with release tags %q fuzz targets are %v, want %v", test.releaseTags, ctx.Targets[0].FuzzTargets, test.wantFuzz)
+		}
+
+		out := &bytes.Buffer{}
+		err := runner.Template.Execute(out, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hasFuzz := strings.Contains(out.String(), "testing.InternalFuzzTarget")
+		if hasFuzz != (test.wantFuzz != nil) {
+			t.Errorf("with release tags %q generated fuzz targets is %t, want %t", test.releaseTags, hasFuzz, test.wantFuzz != nil)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package runner
+
+import (
+	"text/template"
+)
+
+// CoverTemplate generates the reporting of coverage counters added by go tool
+// cover and the merging of the profiles written by each package.
+var CoverTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/internal/testdeps"
+)
+
+var (
+	coverCounters = make(map[string][]uint32)
+	coverBlocks   = make(map[string][]testing.CoverBlock)
+)
+
+// registerCoverFile records the counters go tool cover added to a file.
+func registerCoverFile(fileName string, counter []uint32, pos []uint32, numStmts []uint16) {
+	if 3*len(counter) != len(pos) || len(counter) != len(numStmts) {
+		panic("coverage: mismatched sizes")
+	}
+	if coverCounters[fileName] != nil {
+		return
+	}
+	block := make([]testing.CoverBlock, len(counter))
+	for i := range counter {
+		block[i] = testing.CoverBlock{
+			Line0: pos[3*i+0],
+			Col0:  uint16(pos[3*i+2]),
+			Line1: pos[3*i+1],
+			Col1:  uint16(pos[3*i+2] >> 16),
+			Stmts: numStmts[i],
+		}
+	}
+	coverCounters[fileName] = counter
+	coverBlocks[fileName] = block
+}
+
+// registerCover reports coverage on toolchains where the testing package
+// reads counters registered with testing.RegisterCover.
+func registerCover() {
+	testing.RegisterCover(testing.Cover{
+		Mode:     coverMode,
+		Counters: coverCounters,
+		Blocks:   coverBlocks,
+	})
+}
+
+// coverTestDeps reports coverage on toolchains where testing.RegisterCover is
+// ignored and coverage is reported by the test dependencies instead.
+type coverTestDeps struct {
+	testdeps.TestDeps
+}
+
+func (coverTestDeps) InitRuntimeCoverage() (string, func(string, string) (string, error), func() float64) {
+	if coverMode == "" {
+		return "", nil, nil
+	}
+	return coverMode, coverTearDown, coverFraction
+}
+
+func coverTearDown(coverprofile string, gocoverdir string) (string, error) {
+	if coverprofile != "" {
+		err := writeCoverProfile(coverprofile)
+		if err != nil {
+			return "error writing coverage profile", err
+		}
+	}
+	fmt.Printf("coverage: %.1f%% of statements\n", 100*coverFraction())
+	return "", nil
+}
+
+func coverCount(counters []uint32, i int) uint32 {
+	if coverMode == "atomic" {
+		return atomic.LoadUint32(&counters[i])
+	}
+	return counters[i]
+}
+
+// coverFraction is the fraction of statements run so far.
+func coverFraction() float64 {
+	var total, active int64
+	for name, counters := range coverCounters {
+		blocks := coverBlocks[name]
+		for i := range counters {
+			stmts := int64(blocks[i].Stmts)
+			total += stmts
+			if coverCount(counters, i) > 0 {
+				active += stmts
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(active) / float64(total)
+}
+
+func writeCoverProfile(filename string) error {
+	names := make([]string, 0, len(coverCounters))
+	for name := range coverCounters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "mode: %s\n", coverMode)
+	for _, name := range names {
+		counters := coverCounters[name]
+		blocks := coverBlocks[name]
+		for i := range counters {
+			b := blocks[i]
+			fmt.Fprintf(buf, "%s:%d.%d,%d.%d %d %d\n", name, b.Line0, b.Col0, b.Line1, b.Col1, b.Stmts, coverCount(counters, i))
+		}
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+}
+
+// newCoverFile creates the file passed to -test.coverprofile.
+func newCoverFile() (string, error) {
+	f, err := ioutil.TempFile("", "gophertest-*.cover")
+	if err != nil {
+		return "", err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// coverageProfile merges the profiles written by each package.
+type coverageProfile struct {
+	mutex  sync.Mutex
+	mode   string
+	blocks map[coverageBlock]int64
+}
+
+type coverageBlock struct {
+	file                     string
+	line0, col0, line1, col1 int
+	stmts                    int
+}
+
+// Merge the profile in filename. In set mode a block is covered if any
+// package covered it, otherwise counts are summed.
+func (p *coverageProfile) Merge(filename string) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(b), "\n")
+	if len(lines[0]) == 0 {
+		// The package exited before writing a profile.
+		return nil
+	}
+	if !strings.HasPrefix(lines[0], "mode: ") {
+		return fmt.Errorf("invalid coverage profile %q", filename)
+	}
+	if mode := strings.TrimPrefix(lines[0], "mode: "); mode != p.mode {
+		return fmt.Errorf("coverage profile %q has mode %q, want %q", filename, mode, p.mode)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.blocks == nil {
+		p.blocks = make(map[coverageBlock]int64)
+	}
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return fmt.Errorf("invalid coverage line %q", line)
+		}
+		block := coverageBlock{file: line[:i]}
+		var count int64
+		_, err := fmt.Sscanf(line[i+1:], "%d.%d,%d.%d %d %d",
+			&block.line0, &block.col0, &block.line1, &block.col1, &block.stmts, &count)
+		if err != nil {
+			return fmt.Errorf("invalid coverage line %q: %v", line, err)
+		}
+		if p.mode == "set" {
+			if count > 0 {
+				p.blocks[block] = 1
+			} else if _, ok := p.blocks[block]; !ok {
+				p.blocks[block] = 0
+			}
+		} else {
+			p.blocks[block] += count
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the merged profile in the format read by go tool cover.
+func (p *coverageProfile) WriteFile(filename string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	blocks := make([]coverageBlock, 0, len(p.blocks))
+	for block := range p.blocks {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		a, b := blocks[i], blocks[j]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		if a.line0 != b.line0 {
+			return a.line0 < b.line0
+		}
+		return a.col0 < b.col0
+	})
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "mode: %s\n", p.mode)
+	for _, b := range blocks {
+		fmt.Fprintf(buf, "%s:%d.%d,%d.%d %d %d\n", b.file, b.line0, b.col0, b.line1, b.col1, b.stmts, p.blocks[b])
+	}
+	return writeFileAtomic(filename, buf.Bytes())
+}
+`))
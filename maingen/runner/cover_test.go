@@ -0,0 +1,156 @@
+package runner
+
+import (
+	"bytes"
+	"testing"
+)
+
+// coverTestMain stands in for the runner code the coverage profile depends
+// on.
+const coverTestMain = `package main
+
+import (
+	"time"
+)
+
+const coverMode = "set"
+
+type target struct {
+	importPath string
+	complexity int64
+}
+
+type result struct {
+	importPath string
+	status     string
+	duration   time.Duration
+}
+
+func main() {}
+`
+
+// coverTestDeps stands in for testing/internal/testdeps, which the go command
+// only allows the testing package to import.
+const coverTestDeps = `package testdeps
+
+type TestDeps struct{}
+`
+
+const coverTestCases = `package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCoverageMerge(t *testing.T) {
+	first := "a.go:1.1,3.2 2 %s\n" +
+		"a.go:5.1,6.2 1 %s\n" +
+		"b.go:1.1,2.2 1 %s\n"
+	second := "a.go:1.1,3.2 2 %s\n" +
+		"a.go:5.1,6.2 1 %s\n" +
+		"c.go:1.1,2.2 3 %s\n"
+	tests := []struct {
+		mode   string
+		first  []interface{}
+		second []interface{}
+		want   string
+	}{{
+		mode:   "set",
+		first:  []interface{}{"1", "0", "0"},
+		second: []interface{}{"0", "0", "1"},
+		want: "mode: set\n" +
+			"a.go:1.1,3.2 2 1\n" +
+			"a.go:5.1,6.2 1 0\n" +
+			"b.go:1.1,2.2 1 0\n" +
+			"c.go:1.1,2.2 3 1\n",
+	}, {
+		mode:   "count",
+		first:  []interface{}{"2", "0", "1"},
+		second: []interface{}{"3", "4", "0"},
+		want: "mode: count\n" +
+			"a.go:1.1,3.2 2 5\n" +
+			"a.go:5.1,6.2 1 4\n" +
+			"b.go:1.1,2.2 1 1\n" +
+			"c.go:1.1,2.2 3 0\n",
+	}, {
+		mode:   "atomic",
+		first:  []interface{}{"7", "1", "0"},
+		second: []interface{}{"1", "0", "2"},
+		want: "mode: atomic\n" +
+			"a.go:1.1,3.2 2 8\n" +
+			"a.go:5.1,6.2 1 1\n" +
+			"b.go:1.1,2.2 1 0\n" +
+			"c.go:1.1,2.2 3 2\n",
+	}}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "cover")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			p := &coverageProfile{mode: test.mode}
+			for i, profile := range []string{fmt.Sprintf(first, test.first...), fmt.Sprintf(second, test.second...)} {
+				filename := filepath.Join(dir, strconv.Itoa(i)+".cover")
+				err := ioutil.WriteFile(filename, []byte("mode: "+test.mode+"\n"+profile), 0666)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = p.Merge(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			merged := filepath.Join(dir, "merged.cover")
+			err = p.WriteFile(merged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(merged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("merged profile:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCoverageMergeModeMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "count.cover")
+	err = ioutil.WriteFile(filename, []byte("mode: count\na.go:1.1,3.2 2 5\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &coverageProfile{mode: "set"}
+	err = p.Merge(filename)
+	if err == nil || !strings.Contains(err.Error(), "mode") {
+		t.Errorf("merging a count profile into a set profile: got error %v", err)
+	}
+}
+`
+
+// TestCoverTemplate runs the generated merging of coverage profiles.
+func TestCoverTemplate(t *testing.T) {
+	cover := executeTemplate(t, CoverTemplate, nil)
+	cover = bytes.Replace(cover, []byte(`"testing/internal/testdeps"`), []byte(`"runnertest/testdeps"`), 1)
+	testGenerated(t, map[string][]byte{
+		"main.go":              []byte(coverTestMain),
+		"cover.go":             cover,
+		"history.go":           executeTemplate(t, HistoryTemplate, nil),
+		"cover_test.go":        []byte(coverTestCases),
+		"testdeps/testdeps.go": []byte(coverTestDeps),
+	})
+}
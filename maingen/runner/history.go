@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"text/template"
+)
+
+// HistoryTemplate generates the recording of package durations used to
+// schedule the longest running packages first.
+var HistoryTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// runHistory is persisted to the file passed with -history.
+type runHistory struct {
+	Packages map[string]packageHistory
+}
+
+type packageHistory struct {
+	// Seconds the package took to run last time.
+	Seconds float64
+}
+
+func defaultHistoryFile() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "gophertest", "history.json")
+}
+
+// loadHistory reads the history file, a missing file is an empty history.
+func loadHistory(filename string) (*runHistory, error) {
+	h := &runHistory{}
+	if filename == "" {
+		return h, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, h)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Record the duration of each package that ran to completion.
+func (h *runHistory) Record(results []result) {
+	if h.Packages == nil {
+		h.Packages = make(map[string]packageHistory)
+	}
+	for _, r := range results {
+		switch r.status {
+		case "ok", "fail", "timeout":
+			h.Packages[r.importPath] = packageHistory{
+				Seconds: r.duration.Seconds(),
+			}
+		}
+	}
+}
+
+// Save the history, replacing the file atomically.
+func (h *runHistory) Save(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(h, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, b)
+}
+
+// writeFileAtomic replaces filename with b so that concurrent readers never
+// see a partial file.
+func writeFileAtomic(filename string, b []byte) error {
+	return writeAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+// writeAtomic replaces filename with what write writes, as writeFileAtomic.
+func writeAtomic(filename string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+"-*")
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// ExpectedCosts estimates how long each target will take. Packages with
+// history use their last duration. Others scale their complexity by the
+// seconds per unit of complexity seen in packages with history, or use the
+// complexity as is when there is no history at all.
+func (h *runHistory) ExpectedCosts(targets []target) map[string]float64 {
+	totalSeconds := 0.0
+	totalComplexity := 0.0
+	for _, t := range targets {
+		if p, ok := h.Packages[t.importPath]; ok && t.complexity > 0 {
+			totalSeconds += p.Seconds
+			totalComplexity += float64(t.complexity)
+		}
+	}
+	scale := 1.0
+	if totalSeconds > 0 && totalComplexity > 0 {
+		scale = totalSeconds / totalComplexity
+	}
+	costs := make(map[string]float64, len(targets))
+	for _, t := range targets {
+		if p, ok := h.Packages[t.importPath]; ok {
+			costs[t.importPath] = p.Seconds
+		} else {
+			costs[t.importPath] = float64(t.complexity) * scale
+		}
+	}
+	return costs
+}
+
+// sortByCost orders targets from most to least expensive.
+func sortByCost(targets []target, costs map[string]float64) {
+	sort.SliceStable(targets, func(i, j int) bool {
+		return costs[targets[i].importPath] > costs[targets[j].importPath]
+	})
+}
+`))
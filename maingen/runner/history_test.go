@@ -0,0 +1,233 @@
+package runner
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hpidcock/gophertest/util"
+)
+
+// historyTestMain stands in for the runner code the history depends on.
+const historyTestMain = `package main
+
+import (
+	"time"
+)
+
+type target struct {
+	importPath string
+	complexity int64
+}
+
+type result struct {
+	importPath string
+	status     string
+	duration   time.Duration
+}
+
+func main() {}
+`
+
+const historyTestCases = `package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpectedCosts(t *testing.T) {
+	targets := []target{{"a", 10}, {"b", 30}, {"c", 20}, {"d", 0}}
+	tests := []struct {
+		name     string
+		packages map[string]packageHistory
+		want     map[string]float64
+	}{
+		{"no history", nil, map[string]float64{"a": 10, "b": 30, "c": 20, "d": 0}},
+		{
+			// 20s for 40 units of complexity, so c is expected to take 10s.
+			"scaled by history",
+			map[string]packageHistory{"a": {Seconds: 5}, "b": {Seconds: 15}},
+			map[string]float64{"a": 5, "b": 15, "c": 10, "d": 0},
+		},
+		{
+			"history of packages no longer run",
+			map[string]packageHistory{"a": {Seconds: 1}, "e": {Seconds: 100}},
+			map[string]float64{"a": 1, "b": 3, "c": 2, "d": 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &runHistory{Packages: test.packages}
+			got := h.ExpectedCosts(targets)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ExpectedCosts() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSortByCost(t *testing.T) {
+	targets := []target{{"a", 0}, {"b", 0}, {"c", 0}, {"d", 0}}
+	sortByCost(targets, map[string]float64{"a": 1, "b": 3, "c": 1, "d": 2})
+	var got []string
+	for _, t := range targets {
+		got = append(got, t.importPath)
+	}
+	// Packages with the same cost keep their order.
+	want := []string{"b", "d", "a", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted %q, want %q", got, want)
+	}
+}
+
+func TestHistoryRecordSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophertest-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "gophertest", "history.json")
+
+	h, err := loadHistory(filename)
+	if err != nil {
+		t.Fatalf("loading missing history: %v", err)
+	}
+	h.Record([]result{
+		{"ok", "ok", 2 * time.Second},
+		{"fail", "fail", time.Second},
+		{"timeout", "timeout", 10 * time.Second},
+		{"cached", "cached", time.Millisecond},
+		{"interrupted", "interrupted", time.Millisecond},
+	})
+	err = h.Save(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the history file is left behind.
+	files, err := ioutil.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "history.json" {
+		t.Errorf("history directory contains %v", files)
+	}
+
+	loaded, err := loadHistory(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]packageHistory{"ok": {2}, "fail": {1}, "timeout": {10}}
+	if !reflect.DeepEqual(loaded.Packages, want) {
+		t.Errorf("loaded %v, want %v", loaded.Packages, want)
+	}
+
+	// Later runs replace the packages they ran.
+	loaded.Record([]result{{"ok", "ok", time.Second}})
+	err = loaded.Save(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = loadHistory(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want["ok"] = packageHistory{1}
+	if !reflect.DeepEqual(loaded.Packages, want) {
+		t.Errorf("loaded %v after a second run, want %v", loaded.Packages, want)
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	h, err := loadHistory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Packages) != 0 {
+		t.Errorf("loaded %v", h.Packages)
+	}
+	h.Record([]result{{"ok", "ok", time.Second}})
+	err = h.Save("")
+	if err != nil {
+		t.Errorf("Save(\"\") = %v", err)
+	}
+}
+`
+
+// TestHistoryTemplate runs the generated history.
+func TestHistoryTemplate(t *testing.T) {
+	testGenerated(t, map[string][]byte{
+		"main.go":         []byte(historyTestMain),
+		"history.go":      executeTemplate(t, HistoryTemplate, nil),
+		"history_test.go": []byte(historyTestCases),
+	})
+}
+
+// TestTemplateHistoryFile runs the generated runner, which records history in
+// the user cache directory unless -history names another file or is empty.
+func TestTemplateHistoryFile(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	dir, err := ioutil.TempDir("", "gophertest-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"go.mod":       "module runnertest\n\ngo 1.16\n",
+		"pass/pass.go": "package pass\n\nimport \"testing\"\n\nfunc TestPass(t *testing.T) {}\n",
+	})
+	bin := buildRunner(t, dir, Context{
+		Fuzz:    util.HasReleaseTag(build.Default, "go1.18"),
+		Targets: []Target{testTarget(dir, "pass", "TestPass")},
+	})
+
+	home := filepath.Join(dir, "home")
+	customFile := filepath.Join(dir, "custom", "history.json")
+	// want is where the history file is written, in the user cache
+	// directory in home by default.
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-history="}, ""},
+		{[]string{"-history", customFile}, customFile},
+		{nil, home},
+	}
+	for _, test := range tests {
+		os.RemoveAll(home)
+		os.RemoveAll(filepath.Dir(customFile))
+		cmd := exec.Command(bin, append([]string{"-nocache"}, test.args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "HOME="+home, "XDG_CACHE_HOME="+filepath.Join(home, "cache"), "LocalAppData="+filepath.Join(home, "cache"))
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("runner %q: %v\n%s", test.args, err, out)
+		}
+
+		var got []string
+		filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+			if err == nil && info.Name() == "history.json" {
+				got = append(got, filename)
+			}
+			return nil
+		})
+		if test.want == "" {
+			if len(got) > 0 {
+				t.Errorf("runner %q wrote history files %q, want none", test.args, got)
+			}
+		} else if len(got) != 1 || !strings.HasPrefix(got[0], test.want) {
+			t.Errorf("runner %q wrote history files %q, want one in %q", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package runner
+
+import (
+	"text/template"
+)
+
+// JSONTemplate generates a converter from verbose test output to
+// test2json compatible events.
+var JSONTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// testEvent matches the output of 'go tool test2json'.
+type testEvent struct {
+	Time    *time.Time ` + "`json:\",omitempty\"`" + `
+	Action  string
+	Package string   ` + "`json:\",omitempty\"`" + `
+	Test    string   ` + "`json:\",omitempty\"`" + `
+	Elapsed *float64 ` + "`json:\",omitempty\"`" + `
+	Output  *string  ` + "`json:\",omitempty\"`" + `
+}
+
+// testEventEncoder serialises events from concurrently running packages.
+type testEventEncoder struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func newTestEventEncoder(w io.Writer) *testEventEncoder {
+	return &testEventEncoder{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (e *testEventEncoder) Encode(event *testEvent) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.encoder.Encode(event)
+}
+
+// testReports end a test. A report indented by four spaces for each level of
+// subtest is only emitted once the output following it has been, as with
+// test2json.
+var testReports = []struct {
+	prefix string
+	action string
+}{
+	{"--- PASS: ", "pass"},
+	{"--- FAIL: ", "fail"},
+	{"--- SKIP: ", "skip"},
+	{"--- BENCH: ", "bench"},
+}
+
+// testMarkers switch the current test, those without an action only change
+// which test the following output belongs to and are not output themselves.
+var testMarkers = []struct {
+	prefix string
+	action string
+}{
+	{"=== RUN   ", "run"},
+	{"=== PAUSE ", "pause"},
+	{"=== CONT  ", "cont"},
+	{"=== NAME  ", ""},
+}
+
+// testConverter parses the verbose output of a test binary and emits an
+// event for each line and each change in test state.
+type testConverter struct {
+	importPath string
+	handlers   []func(*testEvent) error
+	partial    []byte
+	current    string
+	reports    []*testEvent
+	err        error
+}
+
+func newTestConverter(importPath string, handlers ...func(*testEvent) error) *testConverter {
+	c := &testConverter{
+		importPath: importPath,
+		handlers:   handlers,
+	}
+	c.emit(&testEvent{Action: "start"})
+	return c
+}
+
+func (c *testConverter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			c.partial = append(c.partial, b...)
+			break
+		}
+		line := append(c.partial, b[:i+1]...)
+		c.partial = nil
+		c.line(string(line))
+		b = b[i+1:]
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return n, nil
+}
+
+// Finish flushes any partial line and pending reports and emits the final
+// package event.
+func (c *testConverter) Finish(status string, elapsed time.Duration) error {
+	if len(c.partial) > 0 {
+		c.line(string(c.partial) + "\n")
+		c.partial = nil
+	}
+	c.flushReports(0)
+	action := "pass"
+	if status != "ok" && status != "cached" {
+		action = "fail"
+	}
+	seconds := elapsed.Seconds()
+	c.output(fmtTestStatus(status, c.importPath, elapsed))
+	c.emit(&testEvent{Action: action, Elapsed: &seconds})
+	return c.err
+}
+
+func (c *testConverter) line(line string) {
+	trimmed := strings.TrimRight(line, "\r\n")
+	if trimmed == "PASS" || trimmed == "FAIL" || strings.HasPrefix(trimmed, "FAIL\t") {
+		c.flushReports(0)
+		c.output(line)
+		return
+	}
+	if trimmed == "=== NAME" {
+		// The trailing spaces of an empty name may have been lost.
+		line = "=== NAME  \n"
+	}
+	for _, marker := range testMarkers {
+		if !strings.HasPrefix(line, marker.prefix) {
+			continue
+		}
+		c.flushReports(0)
+		c.current = strings.TrimSpace(line[len(marker.prefix):])
+		switch marker.action {
+		case "":
+		case "pause":
+			// The pause is output before its event so that the test does not
+			// appear to produce output once paused.
+			c.output(line)
+			c.emit(&testEvent{Action: marker.action, Test: c.current})
+		default:
+			c.emit(&testEvent{Action: marker.action, Test: c.current})
+			c.output(line)
+		}
+		return
+	}
+	indent := 0
+	unindented := line
+	for strings.HasPrefix(unindented, "    ") {
+		unindented = unindented[4:]
+		indent++
+	}
+	for _, report := range testReports {
+		if !strings.HasPrefix(unindented, report.prefix) {
+			continue
+		}
+		if indent > len(c.reports) {
+			// Nested deeper than any test still reporting, so it is output.
+			break
+		}
+		name, elapsed := parseTestReport(strings.TrimSpace(unindented[len(report.prefix):]))
+		c.flushReports(indent)
+		event := &testEvent{Action: report.action, Test: name}
+		if elapsed >= 0 {
+			event.Elapsed = &elapsed
+		}
+		c.reports = append(c.reports, event)
+		c.current = name
+		c.output(line)
+		return
+	}
+	// Indented output following reports belongs to the test reported at
+	// that depth.
+	if indent > 0 && indent <= len(c.reports) {
+		c.current = c.reports[indent-1].Test
+	}
+	c.output(line)
+}
+
+// flushReports emits the pending reports nested depth or deeper, innermost
+// first.
+func (c *testConverter) flushReports(depth int) {
+	c.current = ""
+	for len(c.reports) > depth {
+		event := c.reports[len(c.reports)-1]
+		c.reports = c.reports[:len(c.reports)-1]
+		c.emit(event)
+	}
+}
+
+func (c *testConverter) output(line string) {
+	c.emit(&testEvent{Action: "output", Test: c.current, Output: &line})
+}
+
+func (c *testConverter) emit(event *testEvent) {
+	if c.err != nil {
+		return
+	}
+	now := time.Now()
+	event.Time = &now
+	event.Package = c.importPath
+	for _, handler := range c.handlers {
+		c.err = handler(event)
+		if c.err != nil {
+			return
+		}
+	}
+}
+
+// parseTestReport splits "TestName (0.00s)" into its name and elapsed
+// seconds. A negative elapsed is returned if it is not present.
+func parseTestReport(s string) (string, float64) {
+	i := strings.LastIndex(s, " (")
+	if i < 0 || !strings.HasSuffix(s, "s)") {
+		return s, -1
+	}
+	elapsed, err := strconv.ParseFloat(s[i+2:len(s)-2], 64)
+	if err != nil {
+		return s, -1
+	}
+	return s[:i], elapsed
+}
+`))
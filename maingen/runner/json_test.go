@@ -0,0 +1,209 @@
+package runner
+
+import (
+	"testing"
+)
+
+// jsonTestMain stands in for the runner code the converter depends on.
+const jsonTestMain = `package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func fmtTestStatus(status string, importPath string, duration time.Duration) string {
+	return fmt.Sprintf("%-4s\t%s\t%.3fs\n", status, importPath, duration.Seconds())
+}
+
+func main() {}
+`
+
+const jsonTestCases = `package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPackage = "example.com/pkg"
+
+func TestConverter(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		input  string
+	}{{
+		name:   "pass",
+		status: "ok",
+		input: "=== RUN   TestA\n" +
+			"hello\n" +
+			"--- PASS: TestA (0.01s)\n" +
+			"=== RUN   TestB\n" +
+			"    b_test.go:10: logged\n" +
+			"--- SKIP: TestB (0.00s)\n" +
+			"PASS\n",
+	}, {
+		name:   "subtests",
+		status: "fail",
+		input: "=== RUN   TestA\n" +
+			"=== RUN   TestA/one\n" +
+			"    a_test.go:12: one\n" +
+			"=== RUN   TestA/two\n" +
+			"=== RUN   TestA/two/deeper\n" +
+			"    a_test.go:15: deeper\n" +
+			"--- FAIL: TestA (0.02s)\n" +
+			"    --- PASS: TestA/one (0.00s)\n" +
+			"    --- FAIL: TestA/two (0.01s)\n" +
+			"        --- FAIL: TestA/two/deeper (0.01s)\n" +
+			"FAIL\n",
+	}, {
+		name:   "output after fail",
+		status: "fail",
+		input: "=== RUN   TestA\n" +
+			"--- FAIL: TestA (0.00s)\n" +
+			"    a_test.go:10: reported after the result\n" +
+			"    a_test.go:11: and again\n" +
+			"=== RUN   TestB\n" +
+			"--- PASS: TestB (0.00s)\n" +
+			"FAIL\n",
+	}, {
+		name:   "parallel",
+		status: "ok",
+		input: "=== RUN   TestA\n" +
+			"=== PAUSE TestA\n" +
+			"=== RUN   TestB\n" +
+			"=== PAUSE TestB\n" +
+			"=== CONT  TestA\n" +
+			"=== CONT  TestB\n" +
+			"    b_test.go:10: from b\n" +
+			"=== NAME  TestA\n" +
+			"    a_test.go:10: from a\n" +
+			"--- PASS: TestA (0.00s)\n" +
+			"=== NAME  TestB\n" +
+			"    b_test.go:11: from b again\n" +
+			"--- PASS: TestB (0.00s)\n" +
+			"PASS\n",
+	}, {
+		name:   "partial line",
+		status: "ok",
+		input: "=== RUN   TestA\n" +
+			"--- PASS: TestA (0.00s)\n" +
+			"PASS",
+	}, {
+		name:   "output outside tests",
+		status: "ok",
+		input: "setting up\n" +
+			"=== RUN   TestA\n" +
+			"--- PASS: TestA (0.00s)\n" +
+			"    a_test.go:10: indented beyond the report\n" +
+			"        --- PASS: TestA/deeper (0.00s)\n" +
+			"PASS\n" +
+			"tearing down\n",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []testEvent
+			conv := newTestConverter(testPackage, func(event *testEvent) error {
+				event.Time = nil
+				if event.Test == "" {
+					event.Elapsed = nil
+				}
+				got = append(got, *event)
+				return nil
+			})
+			// Write a few bytes at a time so that lines are split across
+			// writes.
+			for b := []byte(test.input); len(b) > 0; {
+				n := 5
+				if n > len(b) {
+					n = len(b)
+				}
+				_, err := conv.Write(b[:n])
+				if err != nil {
+					t.Fatal(err)
+				}
+				b = b[n:]
+			}
+			elapsed := 1500 * time.Millisecond
+			err := conv.Finish(test.status, elapsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The converter ends a partial line before the status, as the
+			// go command does.
+			input := test.input
+			if !strings.HasSuffix(input, "\n") {
+				input += "\n"
+			}
+			want := test2json(t, input+fmtTestStatus(test.status, testPackage, elapsed))
+			if len(got) != len(want) {
+				t.Errorf("got %d events, want %d", len(got), len(want))
+			}
+			for i := 0; i < len(got) || i < len(want); i++ {
+				var g, w *testEvent
+				if i < len(got) {
+					g = &got[i]
+				}
+				if i < len(want) {
+					w = &want[i]
+				}
+				if !reflect.DeepEqual(g, w) {
+					t.Errorf("event %d = %s, want %s", i, formatEvent(g), formatEvent(w))
+				}
+			}
+		})
+	}
+}
+
+// test2json converts input with go tool test2json.
+func test2json(t *testing.T, input string) []testEvent {
+	cmd := exec.Command("go", "tool", "test2json", "-t", "-p", testPackage)
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("go tool test2json: %v", err)
+	}
+	var events []testEvent
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		event := testEvent{}
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event.Time = nil
+		if event.Test == "" {
+			// The elapsed time of the package is measured by test2json.
+			event.Elapsed = nil
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func formatEvent(event *testEvent) string {
+	if event == nil {
+		return "none"
+	}
+	b, _ := json.Marshal(event)
+	return string(b)
+}
+`
+
+// TestJSONTemplate compares the events of the generated converter with those
+// of go tool test2json given the same output.
+func TestJSONTemplate(t *testing.T) {
+	testGenerated(t, map[string][]byte{
+		"main.go":      []byte(jsonTestMain),
+		"json.go":      executeTemplate(t, JSONTemplate, nil),
+		"json_test.go": []byte(jsonTestCases),
+	})
+}
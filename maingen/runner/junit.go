@@ -0,0 +1,193 @@
+package runner
+
+import (
+	"text/template"
+)
+
+// JUnitTemplate generates a JUnit XML report writer fed by test events.
+var JUnitTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name          ` + "`xml:\"testsuites\"`" + `
+	Suites  []*junitTestSuite ` + "`xml:\"testsuite\"`" + `
+}
+
+type junitTestSuite struct {
+	Name      string           ` + "`xml:\"name,attr\"`" + `
+	Tests     int              ` + "`xml:\"tests,attr\"`" + `
+	Failures  int              ` + "`xml:\"failures,attr\"`" + `
+	Skipped   int              ` + "`xml:\"skipped,attr\"`" + `
+	Time      string           ` + "`xml:\"time,attr\"`" + `
+	Timestamp string           ` + "`xml:\"timestamp,attr\"`" + `
+	TestCases []*junitTestCase ` + "`xml:\"testcase\"`" + `
+
+	cases  map[string]*junitTestCase
+	output strings.Builder
+}
+
+type junitTestCase struct {
+	Classname string        ` + "`xml:\"classname,attr\"`" + `
+	Name      string        ` + "`xml:\"name,attr\"`" + `
+	Time      string        ` + "`xml:\"time,attr\"`" + `
+	Failure   *junitMessage ` + "`xml:\"failure,omitempty\"`" + `
+	Skipped   *junitMessage ` + "`xml:\"skipped,omitempty\"`" + `
+	SystemOut *junitOutput  ` + "`xml:\"system-out,omitempty\"`" + `
+
+	output strings.Builder
+}
+
+type junitMessage struct {
+	Message string ` + "`xml:\"message,attr\"`" + `
+	Body    string ` + "`xml:\",cdata\"`" + `
+}
+
+type junitOutput struct {
+	Body string ` + "`xml:\",cdata\"`" + `
+}
+
+// junitReport collects a test suite for each package run.
+type junitReport struct {
+	mutex  sync.Mutex
+	suites []*junitTestSuite
+}
+
+// Suite starts a new test suite for the package.
+func (r *junitReport) Suite(importPath string) *junitTestSuite {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	suite := &junitTestSuite{
+		Name:      importPath,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		cases:     make(map[string]*junitTestCase),
+	}
+	r.suites = append(r.suites, suite)
+	return suite
+}
+
+func (r *junitReport) WriteFile(filename string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sort.Slice(r.suites, func(i, j int) bool {
+		return r.suites[i].Name < r.suites[j].Name
+	})
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(xml.Header)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	encoder := xml.NewEncoder(f)
+	encoder.Indent("", "\t")
+	err = encoder.Encode(&junitTestSuites{Suites: r.suites})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = f.WriteString("\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Handle records a test event for this suite.
+func (s *junitTestSuite) Handle(event *testEvent) error {
+	if event.Test == "" {
+		switch event.Action {
+		case "output":
+			s.output.WriteString(*event.Output)
+		case "pass", "fail":
+			s.finish(event)
+		}
+		return nil
+	}
+
+	testCase := s.cases[event.Test]
+	if testCase == nil {
+		testCase = &junitTestCase{
+			Classname: s.Name,
+			Name:      event.Test,
+			Time:      "0.000",
+		}
+		s.cases[event.Test] = testCase
+		s.TestCases = append(s.TestCases, testCase)
+	}
+
+	if event.Elapsed != nil {
+		testCase.Time = fmt.Sprintf("%.3f", *event.Elapsed)
+	}
+	switch event.Action {
+	case "output":
+		testCase.output.WriteString(*event.Output)
+	case "fail":
+		testCase.Failure = &junitMessage{
+			Message: "Failed",
+			Body:    testCase.output.String(),
+		}
+	case "skip":
+		testCase.Skipped = &junitMessage{
+			Message: "Skipped",
+			Body:    testCase.output.String(),
+		}
+	case "pass", "bench":
+		testCase.SystemOut = &junitOutput{
+			Body: testCase.output.String(),
+		}
+	}
+	return nil
+}
+
+func (s *junitTestSuite) finish(event *testEvent) {
+	if event.Elapsed != nil {
+		s.Time = fmt.Sprintf("%.3f", *event.Elapsed)
+	}
+	for _, testCase := range s.TestCases {
+		if event.Action == "fail" && testCase.Failure == nil && testCase.Skipped == nil && testCase.SystemOut == nil {
+			// The test never finished, e.g. it hung and the package was
+			// killed on timeout or interrupt.
+			testCase.Failure = &junitMessage{
+				Message: "Failed",
+				Body:    testCase.output.String() + s.output.String(),
+			}
+		}
+		s.Tests++
+		switch {
+		case testCase.Failure != nil:
+			s.Failures++
+		case testCase.Skipped != nil:
+			s.Skipped++
+		}
+	}
+	if event.Action == "fail" && s.Failures == 0 {
+		// The package failed outside of any test, e.g. a panic in init or
+		// TestMain, so report it against the package itself.
+		s.TestCases = append(s.TestCases, &junitTestCase{
+			Classname: s.Name,
+			Name:      "(package)",
+			Time:      s.Time,
+			Failure: &junitMessage{
+				Message: "Failed",
+				Body:    s.output.String(),
+			},
+		})
+		s.Tests++
+		s.Failures++
+	}
+}
+`))
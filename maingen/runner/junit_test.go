@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"testing"
+)
+
+const junitTestCases = `package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJUnitReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	report := &junitReport{}
+	packages := []struct {
+		importPath string
+		status     string
+		output     string
+	}{{
+		importPath: "example.com/b",
+		status:     "fail",
+		output: "=== RUN   TestA\n" +
+			"=== RUN   TestA/ok\n" +
+			"    a_test.go:10: <b>bold</b> & \"quoted\" ]]> done\n" +
+			"=== RUN   TestA/bad\n" +
+			"=== RUN   TestA/bad/deeper\n" +
+			"    a_test.go:14: broken\n" +
+			"--- FAIL: TestA (0.03s)\n" +
+			"    --- PASS: TestA/ok (0.00s)\n" +
+			"    --- FAIL: TestA/bad (0.02s)\n" +
+			"        --- FAIL: TestA/bad/deeper (0.01s)\n" +
+			"=== RUN   TestB\n" +
+			"    b_test.go:5: not today\n" +
+			"--- SKIP: TestB (0.00s)\n" +
+			"FAIL\n",
+	}, {
+		importPath: "example.com/a",
+		status:     "fail",
+		output:     "panic: in init\n",
+	}, {
+		importPath: "example.com/c",
+		status:     "fail",
+		output: "=== RUN   TestDone\n" +
+			"--- PASS: TestDone (0.00s)\n" +
+			"=== RUN   TestHang\n" +
+			"    c_test.go:8: waiting\n",
+	}}
+	for _, pkg := range packages {
+		conv := newTestConverter(pkg.importPath, report.Suite(pkg.importPath).Handle)
+		_, err := conv.Write([]byte(pkg.output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conv.Finish(pkg.status, 1500*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename := filepath.Join(dir, "junit.xml")
+	err = report.WriteFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), xml.Header) {
+		t.Errorf("report does not start with the XML header:\n%s", b)
+	}
+	got := &junitTestSuites{}
+	err = xml.Unmarshal(b, got)
+	if err != nil {
+		t.Fatalf("parsing report: %v\n%s", err, b)
+	}
+	for _, suite := range got.Suites {
+		suite.Timestamp = ""
+	}
+	want := &junitTestSuites{
+		XMLName: xml.Name{Local: "testsuites"},
+		Suites: []*junitTestSuite{{
+			Name:     "example.com/a",
+			Tests:    1,
+			Failures: 1,
+			Time:     "1.500",
+			TestCases: []*junitTestCase{{
+				Classname: "example.com/a",
+				Name:      "(package)",
+				Time:      "1.500",
+				Failure: &junitMessage{
+					Message: "Failed",
+					Body:    "panic: in init\nfail\texample.com/a\t1.500s\n",
+				},
+			}},
+		}, {
+			Name:     "example.com/b",
+			Tests:    5,
+			Failures: 3,
+			Skipped:  1,
+			Time:     "1.500",
+			TestCases: []*junitTestCase{{
+				Classname: "example.com/b",
+				Name:      "TestA",
+				Time:      "0.030",
+				Failure: &junitMessage{
+					Message: "Failed",
+					Body:    "=== RUN   TestA\n--- FAIL: TestA (0.03s)\n",
+				},
+			}, {
+				Classname: "example.com/b",
+				Name:      "TestA/ok",
+				Time:      "0.000",
+				SystemOut: &junitOutput{
+					Body: "=== RUN   TestA/ok\n" +
+						"    a_test.go:10: <b>bold</b> & \"quoted\" ]]> done\n" +
+						"    --- PASS: TestA/ok (0.00s)\n",
+				},
+			}, {
+				Classname: "example.com/b",
+				Name:      "TestA/bad",
+				Time:      "0.020",
+				Failure: &junitMessage{
+					Message: "Failed",
+					Body:    "=== RUN   TestA/bad\n    --- FAIL: TestA/bad (0.02s)\n",
+				},
+			}, {
+				Classname: "example.com/b",
+				Name:      "TestA/bad/deeper",
+				Time:      "0.010",
+				Failure: &junitMessage{
+					Message: "Failed",
+					Body:    "=== RUN   TestA/bad/deeper\n    a_test.go:14: broken\n        --- FAIL: TestA/bad/deeper (0.01s)\n",
+				},
+			}, {
+				Classname: "example.com/b",
+				Name:      "TestB",
+				Time:      "0.000",
+				Skipped: &junitMessage{
+					Message: "Skipped",
+					Body:    "=== RUN   TestB\n    b_test.go:5: not today\n--- SKIP: TestB (0.00s)\n",
+				},
+			}},
+		}, {
+			Name:     "example.com/c",
+			Tests:    2,
+			Failures: 1,
+			Time:     "1.500",
+			TestCases: []*junitTestCase{{
+				Classname: "example.com/c",
+				Name:      "TestDone",
+				Time:      "0.000",
+				SystemOut: &junitOutput{
+					Body: "=== RUN   TestDone\n--- PASS: TestDone (0.00s)\n",
+				},
+			}, {
+				// Killed while running, it fails with the output of the
+				// package.
+				Classname: "example.com/c",
+				Name:      "TestHang",
+				Time:      "0.000",
+				Failure: &junitMessage{
+					Message: "Failed",
+					Body:    "=== RUN   TestHang\n    c_test.go:8: waiting\nfail\texample.com/c\t1.500s\n",
+				},
+			}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report = %s, want %s", formatReport(got), formatReport(want))
+	}
+}
+
+func formatReport(report *junitTestSuites) string {
+	b, err := xml.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+`
+
+// TestJUnitTemplate runs the generated JUnit report on converted output.
+func TestJUnitTemplate(t *testing.T) {
+	testGenerated(t, map[string][]byte{
+		"main.go":       []byte(jsonTestMain),
+		"json.go":       executeTemplate(t, JSONTemplate, nil),
+		"junit.go":      executeTemplate(t, JUnitTemplate, nil),
+		"junit_test.go": []byte(junitTestCases),
+	})
+}
@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"text/template"
+)
+
+// OutputTemplate generates the writers used to collect test output.
+var OutputTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// spillThreshold is the amount of output held in memory for each stream
+// before it is moved to a temporary file.
+const spillThreshold = 1 << 20
+
+// spillBuffer holds output in memory until it exceeds spillThreshold, after
+// which all output is written to a temporary file.
+type spillBuffer struct {
+	buffer bytes.Buffer
+	file   *os.File
+	size   int64
+}
+
+func (s *spillBuffer) Write(b []byte) (int, error) {
+	s.size += int64(len(b))
+	if s.file == nil && s.buffer.Len()+len(b) <= spillThreshold {
+		return s.buffer.Write(b)
+	}
+	if s.file == nil {
+		f, err := ioutil.TempFile("", "gophertest-*.out")
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		_, err = s.buffer.WriteTo(f)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s.file.Write(b)
+}
+
+// Len is the amount of output written.
+func (s *spillBuffer) Len() int64 {
+	return s.size
+}
+
+// WriteTo copies all buffered output to w.
+func (s *spillBuffer) WriteTo(w io.Writer) (int64, error) {
+	if s.file == nil {
+		return s.buffer.WriteTo(w)
+	}
+	_, err := s.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, s.file)
+}
+
+// Close removes any temporary file.
+func (s *spillBuffer) Close() error {
+	s.buffer.Reset()
+	if s.file == nil {
+		return nil
+	}
+	name := s.file.Name()
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		os.Remove(name)
+		return err
+	}
+	return os.Remove(name)
+}
+
+// lineWriter passes each complete line written to it to fn while holding
+// mutex, so that lines from several writers sharing a mutex never interleave.
+type lineWriter struct {
+	mutex   *sync.Mutex
+	fn      func(line []byte) error
+	partial []byte
+}
+
+func (l *lineWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			l.partial = append(l.partial, b...)
+			break
+		}
+		line := append(l.partial, b[:i+1]...)
+		l.partial = nil
+		b = b[i+1:]
+		l.mutex.Lock()
+		err := l.fn(line)
+		l.mutex.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush passes any partial line to fn.
+func (l *lineWriter) Flush() error {
+	if len(l.partial) == 0 {
+		return nil
+	}
+	line := append(l.partial, '\n')
+	l.partial = nil
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.fn(line)
+}
+
+// prefixLines returns a line handler writing each line to w prefixed with
+// the package import path.
+func prefixLines(w io.Writer, importPath string) func([]byte) error {
+	prefix := []byte(importPath + ": ")
+	return func(line []byte) error {
+		_, err := w.Write(append(append([]byte(nil), prefix...), line...))
+		return err
+	}
+}
+`))
@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"testing"
+)
+
+// outputTestMain stands in for the runner the output writers are part of.
+const outputTestMain = `package main
+
+func main() {}
+`
+
+const outputTestCases = `package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	s := &spillBuffer{}
+	want := &bytes.Buffer{}
+	for i := 0; s.file == nil; i++ {
+		line := fmt.Sprintf("%d %s\n", i, strings.Repeat("x", 1000))
+		want.WriteString(line)
+		_, err := s.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.file == nil && want.Len() > spillThreshold {
+			t.Fatalf("%d bytes held in memory, want at most %d", want.Len(), spillThreshold)
+		}
+	}
+	if s.buffer.Len() != 0 {
+		t.Errorf("%d bytes left in memory after spilling", s.buffer.Len())
+	}
+	// Output after spilling goes to the file too.
+	want.WriteString("last line\n")
+	_, err := s.Write([]byte("last line\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != int64(want.Len()) {
+		t.Errorf("Len() = %d, want %d", s.Len(), want.Len())
+	}
+
+	got := &bytes.Buffer{}
+	_, err = s.WriteTo(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("got %d bytes of output, want the %d bytes written in order", got.Len(), want.Len())
+	}
+
+	name := s.file.Name()
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("temporary file %s not removed: %v", name, err)
+	}
+}
+
+func TestSpillBufferInMemory(t *testing.T) {
+	s := &spillBuffer{}
+	for _, b := range []string{"a\n", "b", "c\n"} {
+		_, err := s.Write([]byte(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.file != nil {
+		t.Errorf("spilled %d bytes to %s", s.Len(), s.file.Name())
+	}
+	got := &bytes.Buffer{}
+	_, err := s.WriteTo(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "a\nbc\n" {
+		t.Errorf("got output %q, want %q", got, "a\nbc\n")
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLineWriterPrefix(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := &lineWriter{mutex: &sync.Mutex{}, fn: prefixLines(out, "example.com/a")}
+	for _, b := range []string{"=== RUN", "   TestA\n", "a\nb\n\n", "partial"} {
+		_, err := l.Write([]byte(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "example.com/a: === RUN   TestA\nexample.com/a: a\nexample.com/a: b\nexample.com/a: \n"
+	if out.String() != want {
+		t.Errorf("before flushing got %q, want %q", out, want)
+	}
+	err := l.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want += "example.com/a: partial\n"
+	if out.String() != want {
+		t.Errorf("after flushing got %q, want %q", out, want)
+	}
+	// Flushing with no partial line writes nothing.
+	err = l.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("after flushing again got %q, want %q", out, want)
+	}
+}
+
+// TestLineWriterInterleave writes lines in pieces from writers sharing a
+// mutex, each line must come out whole.
+func TestLineWriterInterleave(t *testing.T) {
+	out := &bytes.Buffer{}
+	mutex := &sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, name := range []string{"a", "b"} {
+		l := &lineWriter{mutex: mutex, fn: prefixLines(out, name)}
+		name := name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				for _, piece := range []string{name, " line ", fmt.Sprint(i), "\n"} {
+					l.Write([]byte(piece))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	counts := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var prefix, name string
+		var i int
+		_, err := fmt.Sscanf(line, "%s %s line %d", &prefix, &name, &i)
+		if err != nil || prefix != name+":" || i != counts[name] {
+			t.Fatalf("unexpected line %q", line)
+		}
+		counts[name]++
+	}
+	if counts["a"] != 1000 || counts["b"] != 1000 {
+		t.Errorf("got %v lines, want 1000 from each writer", counts)
+	}
+}
+`
+
+// TestOutputTemplate runs the generated output writers.
+func TestOutputTemplate(t *testing.T) {
+	testGenerated(t, map[string][]byte{
+		"main.go":        []byte(outputTestMain),
+		"output.go":      executeTemplate(t, OutputTemplate, nil),
+		"output_test.go": []byte(outputTestCases),
+	})
+}
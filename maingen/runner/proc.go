@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"text/template"
+)
+
+// ProcTemplate generates process group handling for the target platform.
+func ProcTemplate(goos string) *template.Template {
+	if goos == "windows" {
+		return procWindowsTemplate
+	}
+	return procUnixTemplate
+}
+
+var procUnixTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// quitSignal asks a test binary to dump its goroutines and exit.
+var quitSignal os.Signal = syscall.SIGQUIT
+
+// interruptSignals are forwarded to running test binaries.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// setProcessGroup places the command in its own process group so that it
+// and any processes it starts can be signalled together.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+}
+`))
+
+var procWindowsTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// quitSignal asks a test binary to dump its goroutines and exit. There is no
+// equivalent of SIGQUIT on windows so the process is killed.
+var quitSignal os.Signal = os.Kill
+
+// interruptSignals are forwarded to running test binaries.
+var interruptSignals = []os.Signal{os.Interrupt}
+
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Kill()
+}
+`))
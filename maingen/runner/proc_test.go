@@ -0,0 +1,336 @@
+//go:build !windows
+// +build !windows
+
+package runner
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hpidcock/gophertest/util"
+)
+
+// procTestPackages are run by the generated runner to test timeouts and
+// interrupts.
+var procTestPackages = map[string]string{
+	"go.mod": "module runnertest\n\ngo 1.16\n",
+	"hang/hang.go": `package hang
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHang(t *testing.T) {
+	time.Sleep(time.Hour)
+}
+`,
+	"slow/slow.go": `package slow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlow(t *testing.T) {
+	time.Sleep(2 * time.Second)
+}
+`,
+	"pass/pass.go": `package pass
+
+import "testing"
+
+func TestPass(t *testing.T) {}
+`,
+	// wait records the signal it receives, after writing the file to show it
+	// has started.
+	"wait/wait.go": `package wait
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+)
+
+func TestWait(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	filename := os.Getenv("RUNNERTEST_SIGNAL")
+	err := ioutil.WriteFile(filename, nil, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := <-signals
+	err = ioutil.WriteFile(filename, []byte(sig.String()), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Exit(1)
+}
+`,
+	// stubborn ignores SIGQUIT and starts a process that holds its output
+	// open, so only killing the process group ends it.
+	"stubborn/stubborn.go": `package stubborn
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStubborn(t *testing.T) {
+	signal.Ignore(syscall.SIGQUIT)
+	cmd := exec.Command("sleep", "1000")
+	cmd.Stdout = os.Stdout
+	err := cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(os.Getenv("RUNNERTEST_CHILD"), []byte(strconv.Itoa(cmd.Process.Pid)), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Hour)
+}
+`,
+}
+
+// buildProcRunner builds a runner for the packages in procTestPackages.
+func buildProcRunner(t *testing.T, dir string, targets ...Target) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	writeFiles(t, dir, procTestPackages)
+	return buildRunner(t, dir, Context{
+		Fuzz:    util.HasReleaseTag(build.Default, "go1.18"),
+		Targets: targets,
+	})
+}
+
+// startRunner starts the runner without caching results or history.
+func startRunner(dir string, bin string, env []string, args ...string) (*exec.Cmd, *bytes.Buffer, *bytes.Buffer, error) {
+	cmd := exec.Command(bin, append([]string{"-history", "", "-nocache"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "HOME="+dir, "XDG_CACHE_HOME="+filepath.Join(dir, "cache"))
+	cmd.Env = append(cmd.Env, env...)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd, stdout, stderr, cmd.Start()
+}
+
+// waitRunner waits for the runner to exit, failing if it is still running
+// after a minute.
+func waitRunner(t *testing.T, cmd *exec.Cmd) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Minute):
+		cmd.Process.Kill()
+		t.Fatalf("runner is still running after a minute")
+		return nil
+	}
+}
+
+func TestTemplateTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophertest-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := buildProcRunner(t, dir,
+		testTarget(dir, "hang", "TestHang"),
+		testTarget(dir, "slow", "TestSlow"),
+		testTarget(dir, "pass", "TestPass"),
+	)
+	// The slow package is given longer than -timeout.
+	configFile := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(configFile, []byte(`{"Packages": {"runnertest/slow": {"Timeout": "1m"}}}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, stdout, stderr, err := startRunner(dir, bin, nil, "-timeout", "1s", "-config", configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = waitRunner(t, cmd)
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("runner exited with %v, want a failure\n%s%s", err, stdout, stderr)
+	}
+	for _, pattern := range []string{
+		`(?m)^timeout\trunnertest/hang\t1\.\d+s$`,
+		`(?m)^ok  \trunnertest/slow\t[2-9]\.\d+s$`,
+		`(?m)^ok  \trunnertest/pass\t`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(stdout.String()) {
+			t.Errorf("output does not match %q:\n%s", pattern, stdout)
+		}
+	}
+	// The hung package dumps its goroutines on SIGQUIT.
+	if !strings.Contains(stderr.String(), "SIGQUIT") || !strings.Contains(stderr.String(), "TestHang") {
+		t.Errorf("missing goroutine dump:\n%s", stderr)
+	}
+}
+
+// TestTemplateTimeoutKill runs a package that ignores SIGQUIT, which is killed
+// with the process it started after the grace period.
+func TestTemplateTimeoutKill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the kill grace period")
+	}
+	dir, err := ioutil.TempDir("", "gophertest-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := buildProcRunner(t, dir,
+		testTarget(dir, "stubborn", "TestStubborn"),
+		testTarget(dir, "pass", "TestPass"),
+	)
+	childFile := filepath.Join(dir, "child")
+	start := time.Now()
+	cmd, stdout, stderr, err := startRunner(dir, bin, []string{"RUNNERTEST_CHILD=" + childFile}, "-timeout", "1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The test binary is killed by its process group, so the process it
+	// started is cleaned up if the runner fails to.
+	defer func() {
+		b, err := ioutil.ReadFile(childFile)
+		if err != nil {
+			return
+		}
+		if pid, err := strconv.Atoi(string(b)); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}()
+	err = waitRunner(t, cmd)
+	elapsed := time.Since(start)
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("runner exited with %v, want a failure\n%s%s", err, stdout, stderr)
+	}
+	// The grace period of the runner is 10s.
+	if elapsed < 11*time.Second {
+		t.Errorf("runner finished after %v, want at least the timeout and grace period", elapsed)
+	}
+	for _, pattern := range []string{
+		`(?m)^timeout\trunnertest/stubborn\t1[01]\.\d+s$`,
+		`(?m)^ok  \trunnertest/pass\t`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(stdout.String()) {
+			t.Errorf("output does not match %q:\n%s", pattern, stdout)
+		}
+	}
+
+	b, err := ioutil.ReadFile(childFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processRunning(pid) {
+		t.Errorf("process %d started by the test is still running", pid)
+	}
+}
+
+// processRunning reports if the process exists and has not exited. Orphaned
+// processes may not be reaped, so exited processes are found in /proc.
+func processRunning(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	// The state follows the command name, which is in parentheses.
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// TestTemplateInterrupt interrupts the runner while a package is running, which
+// is passed the signal and no more packages are started.
+func TestTemplateInterrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophertest-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bin := buildProcRunner(t, dir,
+		testTarget(dir, "wait", "TestWait"),
+		testTarget(dir, "pass", "TestPass"),
+	)
+	for _, sig := range []os.Signal{os.Interrupt, syscall.SIGTERM} {
+		signalFile := filepath.Join(dir, "signal")
+		os.Remove(signalFile)
+		cmd, stdout, stderr, err := startRunner(dir, bin, []string{"RUNNERTEST_SIGNAL=" + signalFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(signalFile); err == nil {
+				break
+			}
+			if time.Since(start) > time.Minute {
+				cmd.Process.Kill()
+				t.Fatalf("%v: package did not start\n%s%s", sig, stdout, stderr)
+			}
+		}
+		err = cmd.Process.Signal(sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = waitRunner(t, cmd)
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Fatalf("%v: runner exited with %v, want a failure\n%s%s", sig, err, stdout, stderr)
+		}
+
+		received, err := ioutil.ReadFile(signalFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(received) != sig.String() {
+			t.Errorf("%v: package received %q", sig, received)
+		}
+		if strings.Contains(stdout.String(), "runnertest/pass") {
+			t.Errorf("%v: package started after the interrupt:\n%s", sig, stdout)
+		}
+		for _, pattern := range []string{
+			`(?m)^gophertest: received ` + regexp.QuoteMeta(sig.String()) + `, stopping tests$`,
+			`(?m)^interrupted: 1 of 2 packages finished\ninterrupted\trunnertest/wait\t`,
+		} {
+			if !regexp.MustCompile(pattern).MatchString(stderr.String()) {
+				t.Errorf("%v: stderr does not match %q:\n%s", sig, pattern, stderr)
+			}
+		}
+	}
+}
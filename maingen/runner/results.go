@@ -0,0 +1,355 @@
+package runner
+
+import (
+	"text/template"
+)
+
+// ResultsTemplate generates the cache of passing package results.
+var ResultsTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resultMaxAge is how long a result is kept after it was last used.
+const resultMaxAge = 5 * 24 * time.Hour
+
+// resultTrimInterval is how often the result cache is trimmed.
+const resultTrimInterval = 24 * time.Hour
+
+// resultCache stores the output of packages that passed so that they are not
+// run again while their build and the inputs they read are unchanged. Each
+// result is a file with a JSON header line followed by the output of the
+// package, so output is never held in memory.
+type resultCache struct {
+	dir string
+}
+
+type cachedResult struct {
+	// Inputs are the environment variables and files the tests used.
+	Inputs []resultInput
+	// StdoutSize and StderrSize are the lengths of the output following the
+	// header.
+	StdoutSize int64
+	StderrSize int64
+
+	filename string
+}
+
+type resultInput struct {
+	Op   string
+	Name string
+	Hash string
+}
+
+func defaultResultCacheDir() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, "gophertest", "results")
+}
+
+// cacheableFlags are the test flags that can be passed while still using
+// cached results, as with go test. Any other argument disables the cache. The
+// value is whether the flag takes a value.
+var cacheableFlags = map[string]bool{
+	"test.benchtime": true,
+	"test.cpu":       true,
+	"test.failfast":  false,
+	"test.list":      true,
+	"test.parallel":  true,
+	"test.run":       true,
+	"test.short":     false,
+	"test.skip":      true,
+	"test.timeout":   true,
+	"test.v":         false,
+}
+
+func cacheableArgs(args []string) bool {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			return false
+		}
+		name := strings.TrimLeft(args[i], "-")
+		hasValue := false
+		if j := strings.Index(name, "="); j >= 0 {
+			name = name[:j]
+			hasValue = true
+		}
+		takesValue, ok := cacheableFlags[name]
+		if !ok {
+			return false
+		}
+		if takesValue && !hasValue {
+			i++
+		}
+	}
+	return true
+}
+
+// Key identifies running t with args, or is empty if the result cannot be
+// cached.
+func (c *resultCache) Key(t target, args []string) string {
+	if c == nil || t.buildID == "" || !cacheableArgs(args) {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", t.buildID, t.importPath, t.directory)
+	for _, arg := range args {
+		fmt.Fprintf(h, "%s\x00", arg)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *resultCache) filename(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".result")
+}
+
+// Lookup returns the result stored for key if none of its inputs have changed
+// in env or on disk.
+func (c *resultCache) Lookup(key string, env []string) *cachedResult {
+	if key == "" {
+		return nil
+	}
+	filename := c.filename(key)
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	header, err := bufio.NewReader(f).ReadBytes('\n')
+	f.Close()
+	if err != nil {
+		return nil
+	}
+	r := &cachedResult{}
+	err = json.Unmarshal(header, r)
+	if err != nil {
+		return nil
+	}
+	for _, input := range r.Inputs {
+		if hashInput(input.Op, input.Name, env) != input.Hash {
+			return nil
+		}
+	}
+	r.filename = filename
+	// The modification time records when the result was last used.
+	now := time.Now()
+	os.Chtimes(filename, now, now)
+	return r
+}
+
+// Store a passing result for key along with the inputs listed in the test log
+// the package wrote.
+func (c *resultCache) Store(key string, testLog string, dir string, env []string, stdout *spillBuffer, stderr *spillBuffer) error {
+	inputs, err := readTestLog(testLog, dir, env)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(&cachedResult{
+		Inputs:     inputs,
+		StdoutSize: stdout.Len(),
+		StderrSize: stderr.Len(),
+	})
+	if err != nil {
+		return err
+	}
+	return writeAtomic(c.filename(key), func(w io.Writer) error {
+		_, err := w.Write(append(header, '\n'))
+		if err != nil {
+			return err
+		}
+		_, err = stdout.WriteTo(w)
+		if err != nil {
+			return err
+		}
+		_, err = stderr.WriteTo(w)
+		return err
+	})
+}
+
+// Replay writes the cached output as if the package had run.
+func (r *cachedResult) Replay(stdout io.Writer, stderr io.Writer) error {
+	f, err := os.Open(r.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	_, err = reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(stdout, reader, r.StdoutSize)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(stderr, reader, r.StderrSize)
+	return err
+}
+
+// Trim removes results not used within resultMaxAge, at most once every
+// resultTrimInterval.
+func (c *resultCache) Trim(now time.Time) error {
+	marker := filepath.Join(c.dir, "trim.txt")
+	if info, err := os.Stat(marker); err == nil && now.Sub(info.ModTime()) < resultTrimInterval {
+		return nil
+	}
+	err := writeFileAtomic(marker, []byte(now.Format(time.RFC3339)+"\n"))
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(c.dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || now.Sub(info.ModTime()) < resultMaxAge {
+			return nil
+		}
+		if filepath.Ext(filename) != ".result" {
+			return nil
+		}
+		err = os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// newTestLog creates the file passed to -test.testlogfile.
+func newTestLog() (string, error) {
+	f, err := ioutil.TempFile("", "gophertest-*.log")
+	if err != nil {
+		return "", err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// readTestLog reads the environment variables and files recorded by the
+// testing package, relative paths are resolved from dir.
+func readTestLog(filename string, dir string, env []string) ([]resultInput, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(b), "\n")
+	if lines[0] != "# test log" {
+		return nil, fmt.Errorf("invalid test log %q", filename)
+	}
+	seen := map[string]bool{}
+	inputs := []resultInput(nil)
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid test log line %q", line)
+		}
+		op, name := line[:i], line[i+1:]
+		switch op {
+		case "getenv":
+		case "chdir":
+			dir = resolvePath(dir, name)
+			continue
+		case "open", "stat":
+			name = resolvePath(dir, name)
+		default:
+			return nil, fmt.Errorf("unknown test log operation %q", op)
+		}
+		if seen[op+" "+name] {
+			continue
+		}
+		seen[op+" "+name] = true
+		inputs = append(inputs, resultInput{
+			Op:   op,
+			Name: name,
+			Hash: hashInput(op, name, env),
+		})
+	}
+	return inputs, nil
+}
+
+func resolvePath(dir string, name string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// hashInput summarises an environment variable or file as seen by a test.
+// Opened files are hashed by content, directories by their entries and
+// stat calls by size, mode and modification time.
+func hashInput(op string, name string, env []string) string {
+	h := sha256.New()
+	switch op {
+	case "getenv":
+		value, ok := lookupEnv(env, name)
+		fmt.Fprintf(h, "%t %s", ok, value)
+	case "stat":
+		info, err := os.Stat(name)
+		if err != nil {
+			fmt.Fprintf(h, "error %v", os.IsNotExist(err))
+			break
+		}
+		fmt.Fprintf(h, "%d %x %d %t", info.Size(), uint64(info.Mode()), info.ModTime().UnixNano(), info.IsDir())
+	case "open":
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(h, "error %v", os.IsNotExist(err))
+			break
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			fmt.Fprintf(h, "error %v", err)
+			break
+		}
+		if info.IsDir() {
+			names, err := f.Readdirnames(-1)
+			if err != nil {
+				fmt.Fprintf(h, "error %v", err)
+				break
+			}
+			sort.Strings(names)
+			fmt.Fprintf(h, "dir %s", strings.Join(names, "\x00"))
+			break
+		}
+		_, err = io.Copy(h, f)
+		if err != nil {
+			fmt.Fprintf(h, "error %v", err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookupEnv finds name in env, later entries taking precedence as with
+// exec.Cmd.
+func lookupEnv(env []string, name string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], name+"=") {
+			return env[i][len(name)+1:], true
+		}
+	}
+	return "", false
+}
+`))
@@ -0,0 +1,274 @@
+package runner
+
+import (
+	"testing"
+)
+
+// resultsTestMain stands in for the runner code the result cache depends on.
+const resultsTestMain = `package main
+
+import (
+	"time"
+)
+
+type target struct {
+	importPath string
+	directory  string
+	complexity int64
+	buildID    string
+}
+
+type result struct {
+	importPath string
+	status     string
+	duration   time.Duration
+}
+
+func main() {}
+`
+
+const resultsTestCases = `package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheableArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, true},
+		{[]string{"-test.v=true"}, true},
+		{[]string{"-test.run", "TestA", "-test.short"}, true},
+		{[]string{"--test.timeout=10s", "-test.parallel=4"}, true},
+		{[]string{"-test.count=1"}, false},
+		{[]string{"-test.run", "TestA", "-test.count", "1"}, false},
+		{[]string{"-test.coverprofile=c.out"}, false},
+		{[]string{"-test.v=true", "positional"}, false},
+	}
+	for _, test := range tests {
+		got := cacheableArgs(test.args)
+		if got != test.want {
+			t.Errorf("cacheableArgs(%q) = %t, want %t", test.args, got, test.want)
+		}
+	}
+}
+
+func TestResultKey(t *testing.T) {
+	c := &resultCache{dir: "unused"}
+	base := target{importPath: "example.com/a", directory: "/src/a", buildID: "build"}
+	key := c.Key(base, []string{"-test.v=true"})
+	if key == "" {
+		t.Fatal("cacheable target has no key")
+	}
+	if other := c.Key(base, []string{"-test.v=true"}); other != key {
+		t.Errorf("key changed from %q to %q", key, other)
+	}
+
+	uncacheable := []struct {
+		name   string
+		cache  *resultCache
+		target target
+		args   []string
+	}{
+		{"no cache", nil, base, nil},
+		{"no build id", c, target{importPath: "example.com/a", directory: "/src/a"}, nil},
+		{"uncacheable flag", c, base, []string{"-test.v=true", "-test.count=1"}},
+	}
+	for _, test := range uncacheable {
+		if got := test.cache.Key(test.target, test.args); got != "" {
+			t.Errorf("%s: key = %q, want none", test.name, got)
+		}
+	}
+
+	changed := []struct {
+		name   string
+		target target
+		args   []string
+	}{
+		{"build id", target{importPath: "example.com/a", directory: "/src/a", buildID: "other"}, []string{"-test.v=true"}},
+		{"directory", target{importPath: "example.com/a", directory: "/src/b", buildID: "build"}, []string{"-test.v=true"}},
+		{"args", base, []string{"-test.v=true", "-test.run=TestA"}},
+	}
+	for _, test := range changed {
+		if got := c.Key(test.target, test.args); got == key || got == "" {
+			t.Errorf("%s: key = %q, want a new key", test.name, got)
+		}
+	}
+}
+
+func TestResultInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name string, content string) {
+		t.Helper()
+		filename := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(filename), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filename, []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("pkg/testdata/a.txt", "a")
+	writeFile("pkg/sub/b.txt", "b")
+	writeFile("test.log", "# test log\n"+
+		"getenv RESULT_VAR\n"+
+		"open testdata/a.txt\n"+
+		"stat testdata/missing.txt\n"+
+		"chdir sub\n"+
+		"open b.txt\n")
+
+	c := &resultCache{dir: filepath.Join(dir, "cache")}
+	key := c.Key(target{importPath: "example.com/pkg", directory: filepath.Join(dir, "pkg"), buildID: "build"}, nil)
+	env := []string{"RESULT_VAR=1"}
+	stdout := &spillBuffer{}
+	stderr := &spillBuffer{}
+	stdout.Write([]byte("to stdout\n"))
+	stderr.Write([]byte("to stderr\n"))
+	err = c.Store(key, filepath.Join(dir, "test.log"), filepath.Join(dir, "pkg"), env, stdout, stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached := c.Lookup(key, env)
+	if cached == nil {
+		t.Fatal("stored result not found")
+	}
+	gotStdout := &bytes.Buffer{}
+	gotStderr := &bytes.Buffer{}
+	err = cached.Replay(gotStdout, gotStderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotStdout.String() != "to stdout\n" || gotStderr.String() != "to stderr\n" {
+		t.Errorf("replayed %q and %q", gotStdout, gotStderr)
+	}
+
+	tests := []struct {
+		name    string
+		env     []string
+		change  func()
+		restore func()
+	}{
+		{"env changed", []string{"RESULT_VAR=2"}, nil, nil},
+		{"env unset", nil, nil, nil},
+		{"env overridden", []string{"RESULT_VAR=1", "RESULT_VAR=2"}, nil, nil},
+		{
+			"opened file changed", env,
+			func() { writeFile("pkg/testdata/a.txt", "changed") },
+			func() { writeFile("pkg/testdata/a.txt", "a") },
+		},
+		{
+			"file opened after chdir changed", env,
+			func() { writeFile("pkg/sub/b.txt", "changed") },
+			func() { writeFile("pkg/sub/b.txt", "b") },
+		},
+		{
+			"missing file created", env,
+			func() { writeFile("pkg/testdata/missing.txt", "") },
+			func() { os.Remove(filepath.Join(dir, "pkg/testdata/missing.txt")) },
+		},
+	}
+	for _, test := range tests {
+		if test.change != nil {
+			test.change()
+		}
+		if c.Lookup(key, test.env) != nil {
+			t.Errorf("%s: stale result found", test.name)
+		}
+		if test.restore != nil {
+			test.restore()
+		}
+		if c.Lookup(key, env) == nil {
+			t.Errorf("%s: result not found once restored", test.name)
+		}
+	}
+}
+
+func TestResultTrim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := &resultCache{dir: dir}
+	now := time.Now()
+	store := func(name string, lastUsed time.Time) string {
+		t.Helper()
+		key := c.Key(target{importPath: name, buildID: "build"}, nil)
+		filename := c.filename(key)
+		err := writeFileAtomic(filename, []byte("{}\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(filename, lastUsed, lastUsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	old := store("old", now.Add(-resultMaxAge-time.Hour))
+	recent := store("recent", now.Add(-resultMaxAge+time.Hour))
+	// Only results are trimmed.
+	other := filepath.Join(dir, "ab", "other.json")
+	err = writeFileAtomic(other, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(other, now.Add(-resultMaxAge-time.Hour), now.Add(-resultMaxAge-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Trim(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filename, want := range map[string]bool{old: false, other: true, recent: true} {
+		_, err := os.Stat(filename)
+		if got := err == nil; got != want {
+			t.Errorf("%s exists = %t, want %t", filepath.Base(filename), got, want)
+		}
+	}
+
+	// Trimming again within the interval does nothing.
+	older := store("older", now.Add(-resultMaxAge-time.Hour))
+	err = c.Trim(now.Add(resultTrimInterval - time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(older); err != nil {
+		t.Errorf("trimmed within the interval: %v", err)
+	}
+	err = c.Trim(now.Add(resultTrimInterval + time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(older); !os.IsNotExist(err) {
+		t.Errorf("not trimmed after the interval: %v", err)
+	}
+}
+`
+
+// TestResultsTemplate runs the generated result cache.
+func TestResultsTemplate(t *testing.T) {
+	testGenerated(t, map[string][]byte{
+		"main.go":         []byte(resultsTestMain),
+		"results.go":      executeTemplate(t, ResultsTemplate, nil),
+		"history.go":      executeTemplate(t, HistoryTemplate, nil),
+		"output.go":       executeTemplate(t, OutputTemplate, nil),
+		"results_test.go": []byte(resultsTestCases),
+	})
+}
@@ -0,0 +1,54 @@
+package runner
+
+import (
+	"text/template"
+)
+
+// ShardTemplate generates the splitting of targets across several machines.
+var ShardTemplate = template.Must(template.New("").Parse(`// Code generated by 'gophertest'. DO NOT EDIT.
+
+package main
+
+import (
+	"sort"
+)
+
+// shardTargets returns the targets belonging to shard index of total. Without
+// costs targets are dealt out in import path order. With costs the most
+// expensive targets are placed first, each on the shard with the lowest total
+// cost so far. Both are deterministic given the same targets and costs.
+func shardTargets(targets []target, index int, total int, costs map[string]float64) []target {
+	ordered := append([]target(nil), targets...)
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].importPath < ordered[j].importPath
+	})
+
+	var selected []target
+	if costs == nil {
+		for i, t := range ordered {
+			if i%total == index {
+				selected = append(selected, t)
+			}
+		}
+		return selected
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return costs[ordered[i].importPath] > costs[ordered[j].importPath]
+	})
+	shardCosts := make([]float64, total)
+	for _, t := range ordered {
+		shard := 0
+		for i := range shardCosts {
+			if shardCosts[i] < shardCosts[shard] {
+				shard = i
+			}
+		}
+		shardCosts[shard] += costs[t.importPath]
+		if shard == index {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
+`))
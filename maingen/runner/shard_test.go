@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"testing"
+)
+
+// shardTestMain stands in for the runner code shardTargets depends on.
+const shardTestMain = `package main
+
+type target struct {
+	importPath string
+}
+
+func main() {}
+`
+
+const shardTestCases = `package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShardTargets(t *testing.T) {
+	targets := []target{{"e"}, {"c"}, {"a"}, {"d"}, {"b"}}
+	costs := map[string]float64{"a": 1, "b": 5, "c": 3, "d": 2, "e": 4}
+	tests := []struct {
+		name  string
+		index int
+		total int
+		costs map[string]float64
+		want  []string
+	}{
+		{"one shard", 0, 1, nil, []string{"a", "b", "c", "d", "e"}},
+		{"first of two", 0, 2, nil, []string{"a", "c", "e"}},
+		{"second of two", 1, 2, nil, []string{"b", "d"}},
+		{"more shards than targets", 5, 6, nil, nil},
+		{"balanced first of two", 0, 2, costs, []string{"b", "d", "a"}},
+		{"balanced second of two", 1, 2, costs, []string{"e", "c"}},
+		{"balanced one shard", 0, 1, costs, []string{"b", "e", "c", "d", "a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, selected := range shardTargets(targets, test.index, test.total, test.costs) {
+				got = append(got, selected.importPath)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("shardTargets(%d, %d) = %q, want %q", test.index, test.total, got, test.want)
+			}
+		})
+	}
+}
+`
+
+// TestShardTemplate runs the generated shardTargets.
+func TestShardTemplate(t *testing.T) {
+	testGenerated(t, map[string][]byte{
+		"main.go":       []byte(shardTestMain),
+		"shard.go":      executeTemplate(t, ShardTemplate, nil),
+		"shard_test.go": []byte(shardTestCases),
+	})
+}
@@ -6,6 +6,13 @@ import (
 
 type Context struct {
 	Targets []Target
+
+	// Fuzz is set when the testing package supports fuzz tests, from Go 1.18.
+	Fuzz bool
+
+	// CoverMode is set when packages are instrumented for coverage.
+	CoverMode string
+	Cover     []CoverPackage
 }
 
 type Target struct {
@@ -18,13 +25,19 @@ type Target struct {
 	ImportPath string
 	Directory  string
 
-	InitFunc   string
-	XInitFunc  string
-	Main       string
-	Tests      []Test
-	Benchmarks []Test
+	InitFunc    string
+	XInitFunc   string
+	Main        string
+	Tests       []Test
+	Benchmarks  []Test
+	FuzzTargets []Test
+	Examples    []Example
 
 	TestComplexity int64
+
+	// BuildID identifies the build of the test packages and how they are
+	// linked, it is empty when the results of the target cannot be cached.
+	BuildID string
 }
 
 type Test struct {
@@ -32,16 +45,48 @@ type Test struct {
 	Name    string
 }
 
+// CoverPackage is a package instrumented by go tool cover.
+type CoverPackage struct {
+	Package    string
+	ImportPath string
+	Files      []CoverFile
+}
+
+type CoverFile struct {
+	// Name of the file in coverage profiles.
+	Name string
+	// Var holds the counters for the file.
+	Var string
+}
+
+type Example struct {
+	Package   string
+	Name      string
+	Output    string
+	Unordered bool
+}
+
 var Deps = []string{
 	"bytes",
+	"crypto/sha256",
+	"encoding/hex",
+	"encoding/json",
+	"encoding/xml",
 	"flag",
 	"fmt",
 	"io",
+	"io/ioutil",
 	"os",
 	"os/exec",
+	"os/signal",
 	"path",
+	"path/filepath",
 	"sort",
 	"strconv",
+	"strings",
+	"sync",
+	"sync/atomic",
+	"syscall",
 	"testing",
 	"testing/internal/testdeps",
 	"time",
@@ -52,15 +97,17 @@ var Template = template.Must(template.New("").Parse(`// Code generated by 'gophe
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
-	"sort"
 	"strconv"
+	"sync"
 	"testing"
 	"testing/internal/testdeps"
 	"time"
@@ -73,10 +120,29 @@ import (
 	{{.XTestName}} {{.ImportPath | printf "%s_test" | printf "%q"}}
 {{end}}
 {{end}}
+{{range .Cover}}
+	{{.Package}} {{.ImportPath | printf "%q"}}
+{{end}}
 )
 
 const pkgEnvName = "GOPHERTEST_PKG"
 const concurrentEnvName = "GOPHERTEST_CONCURRENT"
+const junitEnvName = "GOPHERTEST_JUNIT"
+const timeoutEnvName = "GOPHERTEST_TIMEOUT"
+const configEnvName = "GOPHERTEST_CONFIG"
+const historyEnvName = "GOPHERTEST_HISTORY"
+const shardIndexEnvName = "GOPHERTEST_SHARD_INDEX"
+const shardTotalEnvName = "GOPHERTEST_SHARD_TOTAL"
+const shardBalanceEnvName = "GOPHERTEST_SHARD_BALANCE"
+const noCacheEnvName = "GOPHERTEST_NOCACHE"
+const coverProfileEnvName = "GOPHERTEST_COVERPROFILE"
+
+// coverMode is set when the test binary was built with coverage.
+const coverMode = {{.CoverMode | printf "%q"}}
+
+// killGracePeriod is how long a test binary has to exit after being signalled
+// before its process group is killed.
+const killGracePeriod = 10 * time.Second
 
 type target struct {
 	name string
@@ -84,15 +150,83 @@ type target struct {
 	directory string
 	tests []testing.InternalTest
 	benchmarks []testing.InternalBenchmark
+{{- if .Fuzz}}
+	fuzzTargets []testing.InternalFuzzTarget
+{{- end}}
 	examples []testing.InternalExample
 	initFunc func()
 	xInitFunc func()
 	testMain func(*testing.M)
 	complexity int64
+	buildID string
 }
 
 var selectedTarget *target
 
+type runOptions struct {
+	concurrent int
+	jsonOutput bool
+	junitFile  string
+	stream     bool
+	timeout    time.Duration
+	configFile  string
+	config      runConfig
+	historyFile string
+	history     *runHistory
+
+	shardIndex   int
+	shardTotal   int
+	shardBalance bool
+
+	noCache bool
+	results *resultCache
+
+	coverProfile string
+}
+
+// runConfig is read from the file passed with -config.
+type runConfig struct {
+	Packages map[string]packageConfig
+}
+
+type packageConfig struct {
+	// Timeout overrides -timeout for the package.
+	Timeout string
+}
+
+func (o *runOptions) loadConfig() error {
+	if o.configFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(o.configFile)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, &o.config)
+	if err != nil {
+		return fmt.Errorf("parsing %q: %v", o.configFile, err)
+	}
+	for importPath, pkgConfig := range o.config.Packages {
+		if pkgConfig.Timeout == "" {
+			continue
+		}
+		_, err := time.ParseDuration(pkgConfig.Timeout)
+		if err != nil {
+			return fmt.Errorf("parsing timeout for %q: %v", importPath, err)
+		}
+	}
+	return nil
+}
+
+func (o *runOptions) timeoutFor(importPath string) time.Duration {
+	pkgConfig, ok := o.config.Packages[importPath]
+	if !ok || pkgConfig.Timeout == "" {
+		return o.timeout
+	}
+	timeout, _ := time.ParseDuration(pkgConfig.Timeout)
+	return timeout
+}
+
 var targets = []target{
 {{range .Targets}}
 	target{
@@ -105,6 +239,7 @@ var targets = []target{
 		testMain: {{.Main}},
 
 		complexity: {{.TestComplexity}},
+		buildID: {{.BuildID | printf "%q"}},
 
 		tests: []testing.InternalTest{
 {{range .Tests}}
@@ -115,6 +250,20 @@ var targets = []target{
 		benchmarks: []testing.InternalBenchmark{
 {{range .Benchmarks}}
 			{"{{.Name}}", {{.Package}}.{{.Name}}},
+{{end}}
+		},
+
+{{if $.Fuzz}}
+		fuzzTargets: []testing.InternalFuzzTarget{
+{{range .FuzzTargets}}
+			{"{{.Name}}", {{.Package}}.{{.Name}}},
+{{end}}
+		},
+{{end}}
+
+		examples: []testing.InternalExample{
+{{range .Examples}}
+			{"{{.Name}}", {{.Package}}.{{.Name}}, {{.Output | printf "%q"}}, {{.Unordered}}},
 {{end}}
 		},
 	},
@@ -125,46 +274,134 @@ func init() {
 	var err error
 	pkg := os.Getenv(pkgEnvName)
 	if pkg == "" {
-		bin := os.Args[0]
-		binDir := path.Dir(bin)
-		for _, t := range targets {
-			if binDir == t.directory {
-				selectedTarget = &t
-				break
-			}
+		opts := runOptions{
+			concurrent: 1,
+			shardTotal: 1,
+			junitFile:  os.Getenv(junitEnvName),
+			configFile: os.Getenv(configEnvName),
+			historyFile: defaultHistoryFile(),
+		}
+		historySet := false
+		if s, ok := os.LookupEnv(historyEnvName); ok {
+			opts.historyFile = s
+			historySet = true
+		}
+		if coverMode != "" {
+			opts.coverProfile = "coverage.out"
+		}
+		if s, ok := os.LookupEnv(coverProfileEnvName); ok {
+			opts.coverProfile = s
 		}
-	}
-
-	if selectedTarget == nil && pkg == "" {
-		concurrent := 1
 		if s := os.Getenv(concurrentEnvName); s != "" {
-			concurrent, err = strconv.Atoi(s)
+			opts.concurrent, err = strconv.Atoi(s)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "error parsing %q: %v", concurrentEnvName, err)
 				os.Exit(1)
 			}
 		}
+		if s := os.Getenv(shardIndexEnvName); s != "" {
+			opts.shardIndex, err = strconv.Atoi(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error parsing %q: %v", shardIndexEnvName, err)
+				os.Exit(1)
+			}
+		}
+		if s := os.Getenv(shardTotalEnvName); s != "" {
+			opts.shardTotal, err = strconv.Atoi(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error parsing %q: %v", shardTotalEnvName, err)
+				os.Exit(1)
+			}
+		}
+		if s := os.Getenv(shardBalanceEnvName); s != "" {
+			opts.shardBalance, err = strconv.ParseBool(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error parsing %q: %v", shardBalanceEnvName, err)
+				os.Exit(1)
+			}
+		}
+		if s := os.Getenv(noCacheEnvName); s != "" {
+			opts.noCache, err = strconv.ParseBool(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error parsing %q: %v", noCacheEnvName, err)
+				os.Exit(1)
+			}
+		}
+		if s := os.Getenv(timeoutEnvName); s != "" {
+			opts.timeout, err = time.ParseDuration(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error parsing %q: %v", timeoutEnvName, err)
+				os.Exit(1)
+			}
+		}
 		fs := flag.NewFlagSet("gophertest", flag.ExitOnError)
-		fs.IntVar(&concurrent, "c", concurrent, "test concurrency")
+		fs.IntVar(&opts.concurrent, "c", opts.concurrent, "test concurrency")
+		fs.BoolVar(&opts.jsonOutput, "json", opts.jsonOutput, "output test2json compatible events")
+		fs.StringVar(&opts.junitFile, "junit", opts.junitFile, "write a JUnit XML report to file")
+		fs.BoolVar(&opts.stream, "stream", opts.stream, "stream output prefixed with the package instead of buffering it when concurrent")
+		fs.DurationVar(&opts.timeout, "timeout", opts.timeout, "per package timeout, 0 to disable")
+		fs.StringVar(&opts.configFile, "config", opts.configFile, "per package configuration file")
+		fs.IntVar(&opts.shardIndex, "shard-index", opts.shardIndex, "index of the shard of packages to run, from 0")
+		fs.IntVar(&opts.shardTotal, "shard-total", opts.shardTotal, "number of shards to split packages into")
+		fs.BoolVar(&opts.shardBalance, "shard-balance", opts.shardBalance, "balance shards by expected duration instead of package count")
+		fs.BoolVar(&opts.noCache, "nocache", opts.noCache, "run every package instead of reusing cached passing results")
+		fs.StringVar(&opts.historyFile, "history", opts.historyFile, "file recording package durations for scheduling, empty to disable")
+		if coverMode != "" {
+			fs.StringVar(&opts.coverProfile, "coverprofile", opts.coverProfile, "write the merged coverage profile to file, empty to disable")
+		}
 		fs.Usage = func() {
 			fmt.Fprintf(fs.Output(), "gophertest: all arguments after -- are passed to the tests\n")
 			fs.PrintDefaults()
 		}
 		fs.Parse(os.Args[1:])
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "history" {
+				historySet = true
+			}
+		})
 		args := fs.Args()
-		if concurrent < 0 {
-			concurrent = 1
+		err = opts.loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+			os.Exit(1)
 		}
-		if concurrent > 1 {
-			sort.Slice(targets, func(i, j int) bool {
-				// Sort from most complex to least.
-				return targets[i].complexity > targets[j].complexity
-			})
+		if opts.concurrent < 0 {
+			opts.concurrent = 1
+		}
+		opts.history, err = loadHistory(opts.historyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading history: %v\n", err)
+			os.Exit(1)
+		}
+		// Cached results would be missing from the coverage profile.
+		if dir := defaultResultCacheDir(); dir != "" && !opts.noCache && opts.coverProfile == "" {
+			opts.results = &resultCache{dir: dir}
+		}
+		if opts.shardTotal < 1 || opts.shardIndex < 0 || opts.shardIndex >= opts.shardTotal {
+			fmt.Fprintf(os.Stderr, "invalid shard %d of %d\n", opts.shardIndex, opts.shardTotal)
+			os.Exit(1)
 		}
-		all(concurrent, args)
+		// Every shard must compute the same split, which the history of
+		// this machine would not give.
+		if opts.shardBalance && !historySet {
+			fmt.Fprintf(os.Stderr, "-shard-balance requires -history to name a history file shared by every shard\n")
+			os.Exit(1)
+		}
+		if opts.shardTotal > 1 {
+			var costs map[string]float64
+			if opts.shardBalance {
+				costs = opts.history.ExpectedCosts(targets)
+			}
+			targets = shardTargets(targets, opts.shardIndex, opts.shardTotal, costs)
+		}
+		if opts.concurrent > 1 {
+			// Start the longest running packages first.
+			sortByCost(targets, opts.history.ExpectedCosts(targets))
+		}
+		all(opts, args)
 	}
 
-	if selectedTarget == nil && pkg != "" {
+	if pkg != "" {
 		for _, t := range targets {
 			if t.importPath == pkg {
 				selectedTarget = &t
@@ -185,6 +422,14 @@ func init() {
 	testdeps.ImportPath = selectedTarget.importPath
 }
 
+func init() {
+{{range $pkg := .Cover}}
+{{range .Files}}
+	registerCoverFile({{.Name | printf "%q"}}, {{$pkg.Package}}.{{.Var}}.Count[:], {{$pkg.Package}}.{{.Var}}.Pos[:], {{$pkg.Package}}.{{.Var}}.NumStmt[:])
+{{end}}
+{{end}}
+}
+
 func defaultMain(m *testing.M) {
 	os.Exit(m.Run())
 }
@@ -193,11 +438,137 @@ func main() {
 	selectedTarget.initFunc()
 	selectedTarget.xInitFunc()
 
-	m := testing.MainStart(testdeps.TestDeps{}, selectedTarget.tests, selectedTarget.benchmarks, nil)
+	if coverMode != "" {
+		registerCover()
+	}
+{{- if .Fuzz}}
+	m := testing.MainStart(coverTestDeps{}, selectedTarget.tests, selectedTarget.benchmarks, selectedTarget.fuzzTargets, selectedTarget.examples)
+{{- else}}
+	m := testing.MainStart(coverTestDeps{}, selectedTarget.tests, selectedTarget.benchmarks, selectedTarget.examples)
+{{- end}}
 	selectedTarget.testMain(m)
 }
 
-func all(concurrent int, args []string) {
+func fmtTestStatus(status string, importPath string, duration time.Duration) string {
+	if status == "cached" {
+		return fmt.Sprintf("%-4s\t%s\t(cached)\n", "ok", importPath)
+	}
+	return fmt.Sprintf("%-4s\t%s\t%.3fs\n", status, importPath, duration.Seconds())
+}
+
+// result of running a test package.
+type result struct {
+	importPath string
+	status     string
+	duration   time.Duration
+}
+
+// processes tracks running test binaries so that they can be signalled when
+// the runner is interrupted.
+type processes struct {
+	mutex       sync.Mutex
+	running     map[*exec.Cmd]struct{}
+	interrupted bool
+}
+
+// Interrupted reports whether Signal has been called.
+func (p *processes) Interrupted() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.interrupted
+}
+
+// Signal the process group of every running test binary and stop any more
+// from being started.
+func (p *processes) Signal(sig os.Signal) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.interrupted = true
+	for cmd := range p.running {
+		signalProcessGroup(cmd, sig)
+	}
+}
+
+func (p *processes) start(cmd *exec.Cmd) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.interrupted {
+		return fmt.Errorf("interrupted")
+	}
+	setProcessGroup(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	if p.running == nil {
+		p.running = make(map[*exec.Cmd]struct{})
+	}
+	p.running[cmd] = struct{}{}
+	return nil
+}
+
+func (p *processes) wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	p.mutex.Lock()
+	delete(p.running, cmd)
+	p.mutex.Unlock()
+	return err
+}
+
+// Run the command, sending it quitSignal if it has not finished within the
+// timeout so that it dumps its goroutines. Once the command exits, or the
+// grace period elapses, its process group is killed.
+func (p *processes) Run(cmd *exec.Cmd, timeout time.Duration) (bool, error) {
+	err := p.start(cmd)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- p.wait(cmd)
+	}()
+	if timeout <= 0 {
+		return false, <-done
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return false, err
+	case <-timer.C:
+	}
+	signalProcessGroup(cmd, quitSignal)
+	exited := false
+	select {
+	case err = <-done:
+		exited = true
+	case <-time.After(killGracePeriod):
+	}
+	signalProcessGroup(cmd, os.Kill)
+	if !exited {
+		err = <-done
+	}
+	return true, err
+}
+
+// forwardSignals passes interrupts to running test binaries, killing them if
+// they have not exited after the grace period or on a second interrupt.
+func forwardSignals(procs *processes) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, interruptSignals...)
+	go func() {
+		sig := <-signals
+		fmt.Fprintf(os.Stderr, "gophertest: received %v, stopping tests\n", sig)
+		procs.Signal(sig)
+		select {
+		case <-signals:
+		case <-time.After(killGracePeriod):
+		}
+		procs.Signal(os.Kill)
+	}()
+}
+
+func all(opts runOptions, args []string) {
 	bin := os.Args[0]
 	if !path.IsAbs(bin) {
 		cwd, err := os.Getwd()
@@ -207,17 +578,41 @@ func all(concurrent int, args []string) {
 		}
 		bin = path.Join(cwd, bin)
 	}
-	slot := make(chan struct{}, concurrent)
-	for i := 0; i < concurrent; i++ {
+	slot := make(chan struct{}, opts.concurrent)
+	for i := 0; i < opts.concurrent; i++ {
 		slot <- struct{}{}
 	}
 	mutex := make(chan struct{}, 1)
 	mutex <- struct{}{}
+	streamMutex := &sync.Mutex{}
+	var events *testEventEncoder
+	if opts.jsonOutput {
+		events = newTestEventEncoder(os.Stdout)
+	}
+	var report *junitReport
+	if opts.junitFile != "" {
+		report = &junitReport{}
+	}
+	if events != nil || report != nil {
+		// Events can only be produced from verbose output.
+		args = append([]string{"-test.v=true"}, args...)
+	}
+	var coverage *coverageProfile
+	if opts.coverProfile != "" {
+		coverage = &coverageProfile{mode: coverMode}
+	}
+	procs := &processes{}
+	forwardSignals(procs)
+	resultsMutex := sync.Mutex{}
+	results := []result(nil)
 	exitCode := 0
 	for _, v := range targets {
 		t := v
 		<-slot
-		os.Setenv(pkgEnvName, t.importPath)
+		if procs.Interrupted() {
+			slot <- struct{}{}
+			break
+		}
 		cmdArgs := []string{}
 		for _, arg := range args {
 			cmdArgs = append(cmdArgs, os.ExpandEnv(arg))
@@ -230,72 +625,220 @@ func all(concurrent int, args []string) {
 			fmt.Fprintf(os.Stderr, "%q is not a directory", t.directory)
 			os.Exit(1)
 		}
-		testBin := path.Join(t.directory, fmt.Sprintf("%s.test", t.name))
-		_, err = os.Stat(testBin)
-		if os.IsNotExist(err) {
-		} else if err != nil {
-			fmt.Fprintf(os.Stderr, "%v", err)
-			os.Exit(1)
-		} else {
-			err = os.Remove(testBin)
+		coverFile := ""
+		if coverage != nil {
+			coverFile, err = newCoverFile()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v", err)
 				os.Exit(1)
 			}
+			cmdArgs = append([]string{"-test.coverprofile=" + coverFile}, cmdArgs...)
 		}
-		err = os.Symlink(bin, testBin)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v", err)
-			os.Exit(1)
-		}
-		cmd := exec.Command(testBin, cmdArgs...)
+		// The test binary is selected by the environment alone, so that
+		// nothing is written to the package directory. The working directory
+		// is the package so relative testdata paths resolve as with go test.
+		cmd := exec.Command(bin, cmdArgs...)
 		cmd.Dir = t.directory
-		cmd.Env = os.Environ()
-		buffer := &bytes.Buffer{}
-		if concurrent == 1 {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), pkgEnvName+"="+t.importPath)
+		// Passing packages are not run again while their build and the
+		// environment and files their tests used are unchanged.
+		cacheKey := opts.results.Key(t, cmdArgs)
+		cached := opts.results.Lookup(cacheKey, cmd.Env)
+		testLog := ""
+		if cacheKey != "" && cached == nil {
+			testLog, err = newTestLog()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v", err)
+				os.Exit(1)
+			}
+			cmd.Args = append(cmd.Args, "-test.testlogfile="+testLog)
+		}
+		var handlers []func(*testEvent) error
+		if events != nil {
+			handlers = append(handlers, events.Encode)
+		}
+		if report != nil {
+			handlers = append(handlers, report.Suite(t.importPath).Handle)
+		}
+		var conv *testConverter
+		if len(handlers) > 0 {
+			conv = newTestConverter(t.importPath, handlers...)
+		}
+		stdoutBuffer := &spillBuffer{}
+		stderrBuffer := &spillBuffer{}
+		var lineWriters []*lineWriter
+		if events != nil {
+			// Events carry their package so they do not need buffering.
+			cmd.Stdout = conv
+			cmd.Stderr = conv
 		} else {
-			cmd.Stdout = buffer
-			cmd.Stderr = buffer
+			var stdout, stderr io.Writer
+			switch {
+			case opts.concurrent == 1:
+				stdout = os.Stdout
+				stderr = os.Stderr
+			case opts.stream:
+				stdoutLines := &lineWriter{mutex: streamMutex, fn: prefixLines(os.Stdout, t.importPath)}
+				stderrLines := &lineWriter{mutex: streamMutex, fn: prefixLines(os.Stderr, t.importPath)}
+				lineWriters = append(lineWriters, stdoutLines, stderrLines)
+				stdout = stdoutLines
+				stderr = stderrLines
+			default:
+				stdout = stdoutBuffer
+				stderr = stderrBuffer
+			}
+			if conv != nil {
+				convMutex := &sync.Mutex{}
+				convWrite := func(line []byte) error {
+					_, err := conv.Write(line)
+					return err
+				}
+				stdoutLines := &lineWriter{mutex: convMutex, fn: convWrite}
+				stderrLines := &lineWriter{mutex: convMutex, fn: convWrite}
+				lineWriters = append(lineWriters, stdoutLines, stderrLines)
+				stdout = io.MultiWriter(stdout, stdoutLines)
+				stderr = io.MultiWriter(stderr, stderrLines)
+			}
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+		}
+		stdoutCapture := &spillBuffer{}
+		stderrCapture := &spillBuffer{}
+		if testLog != "" && cmd.Stdout == cmd.Stderr {
+			// Both streams share one writer so that exec.Cmd copies them from
+			// a single pipe, the output is captured as stdout.
+			cmd.Stdout = io.MultiWriter(cmd.Stdout, stdoutCapture)
+			cmd.Stderr = cmd.Stdout
+		} else if testLog != "" {
+			cmd.Stdout = io.MultiWriter(cmd.Stdout, stdoutCapture)
+			cmd.Stderr = io.MultiWriter(cmd.Stderr, stderrCapture)
 		}
 		go func() {
-			defer func() {
-				err := os.Remove(testBin)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "%v", err)
-					os.Exit(1)
-				}
-			}()
 			start := time.Now()
-			err := cmd.Run()
+			var timedOut bool
+			var err error
+			if cached != nil {
+				err = cached.Replay(cmd.Stdout, cmd.Stderr)
+			} else {
+				timedOut, err = procs.Run(cmd, opts.timeoutFor(t.importPath))
+			}
 			failed := false
-			if exitErr, ok := err.(*exec.ExitError); ok {
+			if procs.Interrupted() && err != nil {
+				failed = true
+			} else if timedOut {
+				failed = true
+			} else if exitErr, ok := err.(*exec.ExitError); ok {
 				failed = exitErr.ExitCode() != 0
 			} else if err != nil {
 				fmt.Fprintf(os.Stderr, "%v", err)
 				os.Exit(1)
 			}
 			duration := time.Since(start).Round(time.Millisecond)
+			if testLog != "" && !failed {
+				err := opts.results.Store(cacheKey, testLog, t.directory, cmd.Env, stdoutCapture, stderrCapture)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "caching result of %s: %v\n", t.importPath, err)
+				}
+			}
+			stdoutCapture.Close()
+			stderrCapture.Close()
+			if testLog != "" {
+				os.Remove(testLog)
+			}
+			if coverFile != "" {
+				err := coverage.Merge(coverFile)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "merging coverage of %s: %v\n", t.importPath, err)
+				}
+				os.Remove(coverFile)
+			}
 			<-mutex
 			status := "ok"
-			if failed {
+			if cached != nil {
+				status = "cached"
+			} else if procs.Interrupted() && failed {
+				status = "interrupted"
+				exitCode++
+			} else if timedOut {
+				status = "timeout"
+				exitCode++
+			} else if failed {
 				status = "fail"
 				exitCode++
 			}
-			_, err = io.Copy(os.Stdout, buffer)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "%v", err)
+			var outErr error
+			for _, lines := range lineWriters {
+				if outErr == nil {
+					outErr = lines.Flush()
+				}
+			}
+			if conv != nil && outErr == nil {
+				outErr = conv.Finish(status, duration)
+			}
+			if events == nil && outErr == nil {
+				_, outErr = stdoutBuffer.WriteTo(os.Stdout)
+			}
+			if events == nil && outErr == nil {
+				_, outErr = stderrBuffer.WriteTo(os.Stderr)
+			}
+			if events == nil && outErr == nil {
+				_, outErr = io.WriteString(os.Stdout, fmtTestStatus(status, t.importPath, duration))
+			}
+			if err := stdoutBuffer.Close(); err != nil && outErr == nil {
+				outErr = err
+			}
+			if err := stderrBuffer.Close(); err != nil && outErr == nil {
+				outErr = err
+			}
+			if outErr != nil {
+				fmt.Fprintf(os.Stderr, "%v", outErr)
 				os.Exit(1)
 			}
-			fmt.Fprintf(os.Stdout, "%-4s\t%s\t%.3fs\n", status, t.importPath, duration.Seconds())
+			resultsMutex.Lock()
+			results = append(results, result{t.importPath, status, duration})
+			resultsMutex.Unlock()
 			mutex <- struct{}{}
 			slot <- struct{}{}
 		}()
 	}
-	for i := 0; i < concurrent; i++ {
+	for i := 0; i < opts.concurrent; i++ {
 		<-slot
 	}
+	if procs.Interrupted() {
+		// Summarise what finished before the interrupt.
+		fmt.Fprintf(os.Stderr, "\ninterrupted: %d of %d packages finished\n", len(results), len(targets))
+		for _, r := range results {
+			fmt.Fprint(os.Stderr, fmtTestStatus(r.status, r.importPath, r.duration))
+		}
+		if exitCode == 0 {
+			exitCode = 1
+		}
+	}
+	opts.history.Record(results)
+	err := opts.history.Save(opts.historyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "writing history: %v\n", err)
+	}
+	if opts.results != nil {
+		err := opts.results.Trim(time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "trimming cached results: %v\n", err)
+		}
+	}
+	if coverage != nil {
+		err := coverage.WriteFile(opts.coverProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "writing coverage profile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if report != nil {
+		err := report.WriteFile(opts.junitFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "writing junit report: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	os.Exit(exitCode)
 }
 
@@ -0,0 +1,218 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hpidcock/gophertest/util"
+)
+
+// templateTestPackage is the package run by the generated runner, it writes
+// to stdout and stderr at the same time.
+const templateTestPackage = `package a
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestOutput(t *testing.T) {
+	wg := sync.WaitGroup{}
+	for _, w := range []*os.File{os.Stdout, os.Stderr} {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				fmt.Fprintf(w, "%s line %d\n", w.Name(), i)
+			}
+		}()
+	}
+	wg.Wait()
+	os.Getenv("RUNNERTEST")
+}
+`
+
+// buildRunner compiles and links the generated runner with the go tools, as
+// gophertest does, since the go command does not allow it to import
+// testing/internal/testdeps.
+func buildRunner(t *testing.T, dir string, ctx Context, flags ...string) string {
+	t.Helper()
+	mainDir := filepath.Join(dir, "main")
+	err := os.Mkdir(mainDir, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"main.go":    executeTemplate(t, Template, ctx),
+		"json.go":    executeTemplate(t, JSONTemplate, nil),
+		"junit.go":   executeTemplate(t, JUnitTemplate, nil),
+		"cover.go":   executeTemplate(t, CoverTemplate, nil),
+		"results.go": executeTemplate(t, ResultsTemplate, nil),
+		"shard.go":   executeTemplate(t, ShardTemplate, nil),
+		"history.go": executeTemplate(t, HistoryTemplate, nil),
+		"output.go":  executeTemplate(t, OutputTemplate, nil),
+		"proc.go":    executeTemplate(t, ProcTemplate(build.Default.GOOS), nil),
+	}
+	var goFiles []string
+	for name, content := range files {
+		filename := filepath.Join(mainDir, name)
+		err := ioutil.WriteFile(filename, content, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		goFiles = append(goFiles, filename)
+	}
+
+	packages := append([]string{"runtime/race"}, Deps...)
+	for _, target := range ctx.Targets {
+		packages = append(packages, target.ImportPath)
+	}
+	listArgs := append([]string{"list", "-export", "-deps", "-f", "{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}"}, flags...)
+	importCfg := runGo(t, dir, append(listArgs, packages...)...)
+	importCfgFile := filepath.Join(dir, "importcfg")
+	err = ioutil.WriteFile(importCfgFile, importCfg, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "main.a")
+	compileArgs := append([]string{"tool", "compile", "-p", "main", "-importcfg", importCfgFile, "-o", archive}, flags...)
+	runGo(t, dir, append(compileArgs, goFiles...)...)
+	bin := filepath.Join(dir, "gopher.test")
+	linkArgs := append([]string{"tool", "link", "-importcfg", importCfgFile, "-o", bin}, flags...)
+	runGo(t, dir, append(linkArgs, archive)...)
+	return bin
+}
+
+// writeFiles writes the files, named relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(filename), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filename, []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testTarget runs the tests of the package in the directory name of the
+// runnertest module in dir.
+func testTarget(dir string, name string, tests ...string) Target {
+	target := Target{
+		ImportTest: true,
+		TestName:   "_" + name,
+		Name:       name,
+		ImportPath: "runnertest/" + name,
+		Directory:  filepath.Join(dir, name),
+		InitFunc:   "func(){}",
+		XInitFunc:  "func(){}",
+		Main:       "defaultMain",
+		BuildID:    "build",
+	}
+	for _, test := range tests {
+		target.Tests = append(target.Tests, Test{Package: target.TestName, Name: test})
+	}
+	return target
+}
+
+func runGo(t *testing.T, dir string, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off")
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("go %s: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return out
+}
+
+// TestTemplateJSONRace runs the generated runner built with the race detector
+// with -json while test results are cached, which captures the output of both
+// streams as it is converted.
+func TestTemplateJSONRace(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	out, err := exec.Command("go", "env", "CGO_ENABLED").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Skip("the race detector requires cgo")
+	}
+	dir, err := ioutil.TempDir("", "gophertest-runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module runnertest\n\ngo 1.16\n",
+		"a/a.go": templateTestPackage,
+	})
+	bin := buildRunner(t, dir, Context{
+		Fuzz:    util.HasReleaseTag(build.Default, "go1.18"),
+		Targets: []Target{testTarget(dir, "a", "TestOutput")},
+	}, "-race")
+
+	// The second run replays the cached result.
+	for _, cached := range []bool{false, true} {
+		cmd := exec.Command(bin, "-json", "-history", "")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "XDG_CACHE_HOME="+filepath.Join(dir, "cache"), "HOME="+dir)
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("cached %t: %v\n%s", cached, err, stderr)
+		}
+		if stderr.Len() > 0 {
+			t.Fatalf("cached %t: unexpected stderr:\n%s", cached, stderr)
+		}
+		if strings.Contains(string(out), "(cached)") != cached {
+			t.Errorf("cached %t: got output\n%s", cached, out)
+		}
+
+		lines := map[string]bool{}
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			event := struct {
+				Action string
+				Test   string
+				Output string
+			}{}
+			err := json.Unmarshal(scanner.Bytes(), &event)
+			if err != nil {
+				t.Fatalf("cached %t: invalid event %q: %v", cached, scanner.Text(), err)
+			}
+			if event.Action == "output" && event.Test == "TestOutput" {
+				lines[event.Output] = true
+			}
+		}
+		for _, stream := range []string{"/dev/stdout", "/dev/stderr"} {
+			for i := 0; i < 200; i++ {
+				line := fmt.Sprintf("%s line %d\n", stream, i)
+				if !lines[line] {
+					t.Errorf("cached %t: missing output %q", cached, line)
+				}
+			}
+		}
+	}
+}
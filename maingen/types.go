@@ -17,6 +17,8 @@ type testPackage struct {
 
 	Benchmarks []benchmark
 	Tests      []test
+	Examples   []example
+	Fuzz       []fuzz
 }
 
 type benchmark struct {
@@ -28,3 +30,26 @@ type test struct {
 	ImportPath string
 	Name       string
 }
+
+type fuzz struct {
+	ImportPath string
+	Name       string
+}
+
+type example struct {
+	ImportPath string
+	Name       string
+	Output     string
+	Unordered  bool
+}
+
+type coverPackage struct {
+	ImportPath string
+	Mode       string
+	Files      []coverFile
+}
+
+type coverFile struct {
+	Name string
+	Var  string
+}
@@ -0,0 +1,22 @@
+package packages
+
+// CgoImports are the packages imported by the code cgo generates, as added by
+// the go command. They are not listed in Imports.
+func (pkg *Package) CgoImports() []string {
+	if len(pkg.CgoFiles) == 0 {
+		return nil
+	}
+	imports := []string{"unsafe"}
+	if !pkg.Standard || pkg.ImportPath != "runtime/cgo" {
+		imports = append(imports, "runtime/cgo")
+	}
+	switch {
+	case !pkg.Standard:
+		imports = append(imports, "syscall")
+	case pkg.ImportPath == "runtime/cgo", pkg.ImportPath == "runtime/race",
+		pkg.ImportPath == "runtime/msan", pkg.ImportPath == "runtime/asan":
+	default:
+		imports = append(imports, "syscall")
+	}
+	return imports
+}
@@ -0,0 +1,36 @@
+package packages
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCgoImports(t *testing.T) {
+	tests := []struct {
+		importPath string
+		standard   bool
+		cgo        bool
+		want       []string
+	}{
+		{"github.com/x/y", false, false, nil},
+		{"github.com/x/y", false, true, []string{"unsafe", "runtime/cgo", "syscall"}},
+		{"net", true, true, []string{"unsafe", "runtime/cgo", "syscall"}},
+		{"runtime/cgo", true, true, []string{"unsafe"}},
+		{"runtime/race", true, true, []string{"unsafe", "runtime/cgo"}},
+		// Only the standard library packages are special.
+		{"runtime/cgo", false, true, []string{"unsafe", "runtime/cgo", "syscall"}},
+	}
+	for _, test := range tests {
+		pkg := &Package{
+			ImportPath: test.importPath,
+			Standard:   test.standard,
+		}
+		if test.cgo {
+			pkg.CgoFiles = []string{"a.go"}
+		}
+		got := pkg.CgoImports()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("CgoImports of %q (standard %t) = %q, want %q", test.importPath, test.standard, got, test.want)
+		}
+	}
+}
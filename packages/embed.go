@@ -0,0 +1,103 @@
+package packages
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// resolveTestEmbed fills in the files embedded by test files, which go list
+// only resolves when run with -test.
+func resolveTestEmbed(pkg *Package) error {
+	var err error
+	if len(pkg.TestEmbedPatterns) > 0 && len(pkg.TestEmbedFiles) == 0 {
+		pkg.TestEmbedFiles, err = resolveEmbed(pkg.Dir, pkg.TestEmbedPatterns)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if len(pkg.XTestEmbedPatterns) > 0 && len(pkg.XTestEmbedFiles) == 0 {
+		pkg.XTestEmbedFiles, err = resolveEmbed(pkg.Dir, pkg.XTestEmbedPatterns)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// resolveEmbed returns the files in dir matched by //go:embed patterns as the
+// go command does. Directories are walked, skipping files starting with . or _
+// unless the pattern starts with all:, and nested modules.
+func resolveEmbed(dir string, patterns []string) ([]string, error) {
+	seen := map[string]struct{}{}
+	files := []string{}
+	add := func(filename string) error {
+		rel, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := seen[rel]; !ok {
+			seen[rel] = struct{}{}
+			files = append(files, rel)
+		}
+		return nil
+	}
+	for _, pattern := range patterns {
+		glob := strings.TrimPrefix(pattern, "all:")
+		all := glob != pattern
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(glob)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "pattern %s", pattern)
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("pattern %s: no matching files found", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if !info.IsDir() {
+				err = add(match)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				continue
+			}
+			err = filepath.Walk(match, func(filename string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if filename == match {
+					return nil
+				}
+				name := info.Name()
+				if !all && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if info.IsDir() {
+					if _, err := os.Stat(filepath.Join(filename, "go.mod")); err == nil {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !info.Mode().IsRegular() {
+					return nil
+				}
+				return add(filename)
+			})
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
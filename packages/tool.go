@@ -7,6 +7,8 @@ import (
 	"os/exec"
 
 	"github.com/pkg/errors"
+
+	"github.com/hpidcock/gophertest/util"
 )
 
 func ImportAll(buildCtx build.Context, dir string, packages []string) ([]*Package, error) {
@@ -30,7 +32,10 @@ func ImportAll(buildCtx build.Context, dir string, packages []string) ([]*Packag
 		if _, ok := testPackage[pkg.ImportPath]; !ok {
 			continue
 		}
-		for _, path := range pkg.Imports {
+		for _, path := range append(pkg.Imports, pkg.CgoImports()...) {
+			if path == "C" {
+				continue
+			}
 			if _, ok := paths[path]; !ok {
 				missing[path] = struct{}{}
 			}
@@ -73,6 +78,7 @@ func internalImportAll(buildCtx build.Context, dir string, packages []string, te
 	}
 
 	args := []string{"list", "-e", "-json", "-compiler", buildCtx.Compiler}
+	args = append(args, util.BuildFlags(buildCtx)...)
 	if !test {
 		args = append(args, "-deps")
 	}
@@ -81,6 +87,7 @@ func internalImportAll(buildCtx build.Context, dir string, packages []string, te
 	cmd := exec.Command("go", append(append(args, "--"), packages...)...)
 	cmd.Stdout = stdout
 	cmd.Dir = dir
+	cmd.Env = util.BuildEnv(buildCtx)
 	err := cmd.Run()
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -94,6 +101,10 @@ func internalImportAll(buildCtx build.Context, dir string, packages []string, te
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		err = resolveTestEmbed(pkg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving test embeds for %q", pkg.ImportPath)
+		}
 		pkgs = append(pkgs, pkg)
 	}
 
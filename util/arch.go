@@ -0,0 +1,100 @@
+package util
+
+import (
+	"go/build"
+	"strings"
+)
+
+// archVars names the variable choosing the instruction set of each GOARCH.
+var archVars = map[string]string{
+	"386":      "GO386",
+	"amd64":    "GOAMD64",
+	"arm":      "GOARM",
+	"arm64":    "GOARM64",
+	"mips":     "GOMIPS",
+	"mipsle":   "GOMIPS",
+	"mips64":   "GOMIPS64",
+	"mips64le": "GOMIPS64",
+	"ppc64":    "GOPPC64",
+	"ppc64le":  "GOPPC64",
+	"riscv64":  "GORISCV64",
+}
+
+// ArchEnv returns the instruction set variable for the target GOARCH, such as
+// GOAMD64=v1, or an empty string if the architecture has none.
+func ArchEnv(buildCtx build.Context) (string, error) {
+	name, ok := archVars[buildCtx.GOARCH]
+	if !ok {
+		return "", nil
+	}
+	values, err := GoEnv(BuildEnv(buildCtx), name)
+	if err != nil {
+		return "", err
+	}
+	return name + "=" + values[name], nil
+}
+
+// AsmDefines are the defines the go command passes to the assembler for the
+// target, archEnv is from ArchEnv.
+func AsmDefines(buildCtx build.Context, archEnv string) []string {
+	defines := []string{
+		"GOOS_" + buildCtx.GOOS,
+		"GOARCH_" + buildCtx.GOARCH,
+	}
+	name, value := archEnv, ""
+	if i := strings.Index(archEnv, "="); i >= 0 {
+		name, value = archEnv[:i], archEnv[i+1:]
+	}
+	switch name {
+	case "GOPPC64":
+		// Each version is a superset of the ones before it.
+		switch value {
+		case "power10":
+			defines = append(defines, "GOPPC64_power10")
+			fallthrough
+		case "power9":
+			defines = append(defines, "GOPPC64_power9")
+			fallthrough
+		default:
+			defines = append(defines, "GOPPC64_power8")
+		}
+	case "GOARM":
+		// The value is a version optionally followed by a floating point mode.
+		switch {
+		case strings.Contains(value, "7"):
+			defines = append(defines, "GOARM_7")
+			fallthrough
+		case strings.Contains(value, "6"):
+			defines = append(defines, "GOARM_6")
+			fallthrough
+		default:
+			defines = append(defines, "GOARM_5")
+		}
+	case "GOARM64":
+		// Large system extensions are only defined, not the version.
+		if arm64LSE(value) {
+			defines = append(defines, "GOARM64_LSE")
+		}
+	case "":
+	default:
+		defines = append(defines, name+"_"+value)
+	}
+	return defines
+}
+
+// arm64LSE reports if a GOARM64 value such as v8.0,lse or v8.1 includes large
+// system extensions, which are required from v8.1.
+func arm64LSE(value string) bool {
+	parts := strings.Split(value, ",")
+	for _, option := range parts[1:] {
+		if option == "lse" {
+			return true
+		}
+	}
+	version := strings.SplitN(strings.TrimPrefix(parts[0], "v"), ".", 2)
+	major, minor := version[0], ""
+	if len(version) > 1 {
+		minor = version[1]
+	}
+	return major > "8" || (major == "8" && minor != "" && minor != "0")
+}
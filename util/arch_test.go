@@ -0,0 +1,37 @@
+package util
+
+import (
+	"go/build"
+	"reflect"
+	"testing"
+)
+
+func TestAsmDefines(t *testing.T) {
+	tests := []struct {
+		goarch  string
+		archEnv string
+		want    []string
+	}{
+		{"amd64", "", []string{"GOOS_linux", "GOARCH_amd64"}},
+		{"amd64", "GOAMD64=v3", []string{"GOOS_linux", "GOARCH_amd64", "GOAMD64_v3"}},
+		{"ppc64le", "GOPPC64=power8", []string{"GOOS_linux", "GOARCH_ppc64le", "GOPPC64_power8"}},
+		{"ppc64le", "GOPPC64=power10", []string{"GOOS_linux", "GOARCH_ppc64le", "GOPPC64_power10", "GOPPC64_power9", "GOPPC64_power8"}},
+		{"arm", "GOARM=5", []string{"GOOS_linux", "GOARCH_arm", "GOARM_5"}},
+		{"arm", "GOARM=6", []string{"GOOS_linux", "GOARCH_arm", "GOARM_6", "GOARM_5"}},
+		{"arm", "GOARM=7,softfloat", []string{"GOOS_linux", "GOARCH_arm", "GOARM_7", "GOARM_6", "GOARM_5"}},
+		{"arm64", "GOARM64=v8.0", []string{"GOOS_linux", "GOARCH_arm64"}},
+		{"arm64", "GOARM64=v8.0,lse", []string{"GOOS_linux", "GOARCH_arm64", "GOARM64_LSE"}},
+		{"arm64", "GOARM64=v8.1", []string{"GOOS_linux", "GOARCH_arm64", "GOARM64_LSE"}},
+		{"arm64", "GOARM64=v9.0", []string{"GOOS_linux", "GOARCH_arm64", "GOARM64_LSE"}},
+	}
+	for _, test := range tests {
+		buildCtx := build.Context{
+			GOOS:   "linux",
+			GOARCH: test.goarch,
+		}
+		got := AsmDefines(buildCtx, test.archEnv)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("AsmDefines(%s, %q) = %q, want %q", test.goarch, test.archEnv, got, test.want)
+		}
+	}
+}
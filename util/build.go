@@ -0,0 +1,101 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/build"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RaceEnabled reports if the build context is for the race detector.
+func RaceEnabled(buildCtx build.Context) bool {
+	return buildCtx.InstallSuffix == "race"
+}
+
+// BuildFlags are the go command flags selecting the same packages and files
+// as the build context. They are always passed so that values in GOFLAGS,
+// which flags given to gophertest override, are not used.
+func BuildFlags(buildCtx build.Context) []string {
+	return []string{
+		"-tags=" + strings.Join(buildCtx.BuildTags, ","),
+		"-race=" + strconv.FormatBool(RaceEnabled(buildCtx)),
+	}
+}
+
+// BuildEnv is the environment for go commands run against the build context,
+// selecting its target rather than the host.
+func BuildEnv(buildCtx build.Context) []string {
+	env := []string{}
+	for _, v := range os.Environ() {
+		switch {
+		case strings.HasPrefix(v, "GOOS="):
+		case strings.HasPrefix(v, "GOARCH="):
+		case strings.HasPrefix(v, "CGO_ENABLED="):
+		default:
+			env = append(env, v)
+		}
+	}
+	env = append(env,
+		"GOOS="+buildCtx.GOOS,
+		"GOARCH="+buildCtx.GOARCH,
+	)
+	if buildCtx.CgoEnabled {
+		env = append(env, "CGO_ENABLED=1")
+	} else {
+		env = append(env, "CGO_ENABLED=0")
+	}
+	return env
+}
+
+// ReleaseTags returns the release tags of the go toolchain, such as go1.18 for
+// Go 1.18 and newer.
+func ReleaseTags(buildCtx build.Context) ([]string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("go", "list", "-e", "-f", `{{join context.ReleaseTags ","}}`, "runtime")
+	cmd.Env = BuildEnv(buildCtx)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.Wrapf(err, "go list: %s", stderr)
+	}
+	return strings.Split(strings.TrimSpace(stdout.String()), ","), nil
+}
+
+// HasReleaseTag reports if the build context has the release tag.
+func HasReleaseTag(buildCtx build.Context, tag string) bool {
+	for _, releaseTag := range buildCtx.ReleaseTags {
+		if releaseTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// GoEnv returns the values of the named variables as reported by go env run
+// with env. Unlike the environment alone this includes settings from the go
+// env file and the defaults of the toolchain.
+func GoEnv(env []string, names ...string) (map[string]string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("go", append([]string{"env", "-json"}, names...)...)
+	cmd.Env = env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.Wrapf(err, "go env: %s", stderr)
+	}
+	values := map[string]string{}
+	err = json.Unmarshal(stdout.Bytes(), &values)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return values, nil
+}
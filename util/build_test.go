@@ -0,0 +1,29 @@
+package util
+
+import (
+	"go/build"
+	"reflect"
+	"testing"
+)
+
+func TestBuildFlags(t *testing.T) {
+	tests := []struct {
+		tags          []string
+		installSuffix string
+		want          []string
+	}{
+		{nil, "", []string{"-tags=", "-race=false"}},
+		{[]string{"a", "b"}, "", []string{"-tags=a,b", "-race=false"}},
+		{[]string{"race"}, "race", []string{"-tags=race", "-race=true"}},
+	}
+	for _, test := range tests {
+		buildCtx := build.Context{
+			BuildTags:     test.tags,
+			InstallSuffix: test.installSuffix,
+		}
+		got := BuildFlags(buildCtx)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("BuildFlags(%q, %q) = %q, want %q", test.tags, test.installSuffix, got, test.want)
+		}
+	}
+}
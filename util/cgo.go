@@ -0,0 +1,29 @@
+package util
+
+import (
+	"go/build"
+	"strings"
+)
+
+// CgoConfig is the C toolchain used to build cgo packages.
+type CgoConfig struct {
+	CC       []string
+	CPPFLAGS []string
+	CFLAGS   []string
+	LDFLAGS  []string
+}
+
+// LoadCgoConfig reads the C compiler and the CGO_ flags for the target from
+// go env.
+func LoadCgoConfig(buildCtx build.Context) (CgoConfig, error) {
+	values, err := GoEnv(BuildEnv(buildCtx), "CC", "CGO_CPPFLAGS", "CGO_CFLAGS", "CGO_LDFLAGS")
+	if err != nil {
+		return CgoConfig{}, err
+	}
+	return CgoConfig{
+		CC:       strings.Fields(values["CC"]),
+		CPPFLAGS: strings.Fields(values["CGO_CPPFLAGS"]),
+		CFLAGS:   strings.Fields(values["CGO_CFLAGS"]),
+		LDFLAGS:  strings.Fields(values["CGO_LDFLAGS"]),
+	}, nil
+}
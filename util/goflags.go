@@ -0,0 +1,102 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GoFlags are the settings from the GOFLAGS environment variable that change
+// which packages and files are built.
+type GoFlags struct {
+	Tags     []string
+	Race     bool
+	TrimPath bool
+	// GcFlags and LdFlags are each of the -gcflags and -ldflags values.
+	GcFlags []string
+	LdFlags []string
+}
+
+// ParseGOFLAGS reads the -tags, -race, -trimpath, -gcflags and -ldflags flags
+// from a GOFLAGS value, other flags are left to the go command. As with the go
+// command a flag whose value contains spaces is quoted whole, such as
+// '-gcflags=all=-N -l'.
+func ParseGOFLAGS(goflags string) (GoFlags, error) {
+	f := GoFlags{}
+	args, err := SplitQuotedFields(goflags)
+	if err != nil {
+		return GoFlags{}, err
+	}
+	for _, arg := range args {
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		value := ""
+		hasValue := false
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		switch name {
+		case "tags":
+			f.Tags = ParseTags(value)
+		case "race":
+			f.Race = true
+			if hasValue {
+				f.Race, _ = strconv.ParseBool(value)
+			}
+		case "trimpath":
+			f.TrimPath = true
+			if hasValue {
+				f.TrimPath, _ = strconv.ParseBool(value)
+			}
+		case "gcflags":
+			f.GcFlags = append(f.GcFlags, value)
+		case "ldflags":
+			f.LdFlags = append(f.LdFlags, value)
+		}
+	}
+	return f, nil
+}
+
+// ParseTags splits a -tags value. Tags are comma separated, the older space
+// separated form is also accepted.
+func ParseTags(value string) []string {
+	sep := ","
+	if !strings.Contains(value, ",") {
+		sep = " "
+	}
+	var tags []string
+	for _, tag := range strings.Split(value, sep) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// SplitQuotedFields splits s on spaces, keeping single or double quoted
+// strings together.
+func SplitQuotedFields(s string) ([]string, error) {
+	var fields []string
+	for {
+		s = strings.TrimLeft(s, " \t\n\r")
+		if s == "" {
+			return fields, nil
+		}
+		if s[0] == '"' || s[0] == '\'' {
+			quote := s[0]
+			i := strings.IndexByte(s[1:], quote)
+			if i < 0 {
+				return nil, fmt.Errorf("unterminated %c string", quote)
+			}
+			fields = append(fields, s[1:i+1])
+			s = s[i+2:]
+			continue
+		}
+		i := strings.IndexAny(s, " \t\n\r")
+		if i < 0 {
+			i = len(s)
+		}
+		fields = append(fields, s[:i])
+		s = s[i:]
+	}
+}
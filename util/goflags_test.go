@@ -0,0 +1,95 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGOFLAGS(t *testing.T) {
+	tests := []struct {
+		goflags string
+		want    GoFlags
+	}{
+		{"", GoFlags{}},
+		{"-mod=mod -count=1", GoFlags{}},
+		{"-tags=a,b", GoFlags{Tags: []string{"a", "b"}}},
+		{"--tags=a", GoFlags{Tags: []string{"a"}}},
+		{"-race", GoFlags{Race: true}},
+		{"-race=false", GoFlags{}},
+		{"-race=true -trimpath", GoFlags{Race: true, TrimPath: true}},
+		{"-trimpath=0", GoFlags{}},
+		{"-gcflags=-N -gcflags=all=-l", GoFlags{GcFlags: []string{"-N", "all=-l"}}},
+		{"-ldflags=-s", GoFlags{LdFlags: []string{"-s"}}},
+		{"-tags=a -tags=b", GoFlags{Tags: []string{"b"}}},
+		{"'-gcflags=all=-N -l' -race", GoFlags{Race: true, GcFlags: []string{"all=-N -l"}}},
+		{`"-ldflags=-s -w" -tags=a`, GoFlags{Tags: []string{"a"}, LdFlags: []string{"-s -w"}}},
+	}
+	for _, test := range tests {
+		got, err := ParseGOFLAGS(test.goflags)
+		if err != nil {
+			t.Errorf("ParseGOFLAGS(%q): %v", test.goflags, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseGOFLAGS(%q) = %#v, want %#v", test.goflags, got, test.want)
+		}
+	}
+}
+
+func TestParseGOFLAGSErrors(t *testing.T) {
+	for _, goflags := range []string{`'-gcflags=-N -l`, `-race "-tags=a`} {
+		if _, err := ParseGOFLAGS(goflags); err == nil {
+			t.Errorf("ParseGOFLAGS(%q) succeeded, want error", goflags)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a,b", []string{"a", "b"}},
+		{" a , b ,", []string{"a", "b"}},
+		{"a b", []string{"a", "b"}},
+		{"a  b", []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		got := ParseTags(test.value)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseTags(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestSplitQuotedFields(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"-N", []string{"-N"}},
+		{" -N\t-l\n", []string{"-N", "-l"}},
+		{`-X "main.v=a b"`, []string{"-X", "main.v=a b"}},
+		{`-X 'main.v=a "b"'`, []string{"-X", `main.v=a "b"`}},
+		{`"" -N`, []string{"", "-N"}},
+	}
+	for _, test := range tests {
+		got, err := SplitQuotedFields(test.s)
+		if err != nil {
+			t.Errorf("SplitQuotedFields(%q): %v", test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitQuotedFields(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+	for _, s := range []string{`"a`, `-X 'b`} {
+		if _, err := SplitQuotedFields(s); err == nil {
+			t.Errorf("SplitQuotedFields(%q) succeeded, want error", s)
+		}
+	}
+}
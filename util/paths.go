@@ -2,6 +2,7 @@ package util
 
 import (
 	"go/build"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
@@ -10,10 +11,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-func PackageCacheDir(cacheDir string, importPath string) string {
-	return path.Join(cacheDir, strings.TrimSuffix(importPath, "_test"))
-}
-
 func LockDirectory(dir string) (lockfile.Lockfile, error) {
 	lockFile, err := lockfile.New(path.Join(dir, ".lock"))
 	if err != nil {
@@ -22,15 +19,50 @@ func LockDirectory(dir string) (lockfile.Lockfile, error) {
 	return lockFile, lockFile.TryLock()
 }
 
-func CacheDir(buildCtx build.Context) (string, error) {
+// CacheRoot is the directory holding the caches of every target.
+func CacheRoot() (string, error) {
 	cacheDir, err := os.UserCacheDir()
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
-	dir := path.Join(cacheDir, "gophertest", buildCtx.GOOS+"_"+buildCtx.GOARCH)
+	return path.Join(cacheDir, "gophertest"), nil
+}
+
+func CacheDir(buildCtx build.Context) (string, error) {
+	root, err := CacheRoot()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	// Race and other install suffixes use a separate cache so their objects
+	// never mix with regular builds.
+	name := buildCtx.GOOS + "_" + buildCtx.GOARCH
+	if buildCtx.InstallSuffix != "" {
+		name += "_" + buildCtx.InstallSuffix
+	}
+	dir := path.Join(root, name)
 	err = os.MkdirAll(dir, 0777)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
 	return dir, nil
 }
+
+// TargetCacheDirs lists the target caches made by CacheDir in root. Other
+// entries in root, such as the server and test result caches, are skipped.
+func TargetCacheDirs(root string) ([]string, error) {
+	infos, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var dirs []string
+	for _, info := range infos {
+		// Target caches are named GOOS_GOARCH, with any install suffix.
+		if !info.IsDir() || !strings.Contains(info.Name(), "_") {
+			continue
+		}
+		dirs = append(dirs, path.Join(root, info.Name()))
+	}
+	return dirs, nil
+}
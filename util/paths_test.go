@@ -0,0 +1,46 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestTargetCacheDirs(t *testing.T) {
+	root, err := ioutil.TempDir("", "gophertest-paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{"linux_amd64", "linux_amd64_race", "darwin_arm64", "server", "results"} {
+		err := os.Mkdir(path.Join(root, dir), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(path.Join(root, "history.json"), []byte("{}"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := TargetCacheDirs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		path.Join(root, "darwin_arm64"),
+		path.Join(root, "linux_amd64"),
+		path.Join(root, "linux_amd64_race"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TargetCacheDirs = %q, want %q", got, want)
+	}
+
+	got, err = TargetCacheDirs(path.Join(root, "missing"))
+	if got != nil || err != nil {
+		t.Errorf("TargetCacheDirs of a missing root = %q, %v, want none", got, err)
+	}
+}
@@ -0,0 +1,18 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MatchPattern returns a function reporting if a name matches pattern. As
+// with the go command, "..." matches any string and a pattern ending in "/..."
+// also matches the path before it.
+func MatchPattern(pattern string) func(name string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	return regexp.MustCompile(`^` + re + `$`).MatchString
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"github.com/x/y", "github.com/x/y", true},
+		{"github.com/x/y", "github.com/x/y/z", false},
+		{"github.com/x/y/...", "github.com/x/y", true},
+		{"github.com/x/y/...", "github.com/x/y/z/w", true},
+		{"github.com/x/y/...", "github.com/x/yz", false},
+		{"github.com/x/...y", "github.com/x/zy", true},
+		{"github.com/.../y", "github.com/x/z/y", true},
+		{"...", "anything", true},
+		{"net/http", "net/httptest", false},
+		{"a.b", "axb", false},
+	}
+	for _, test := range tests {
+		got := MatchPattern(test.pattern)(test.name)
+		if got != test.want {
+			t.Errorf("MatchPattern(%q)(%q) = %t, want %t", test.pattern, test.name, got, test.want)
+		}
+	}
+}
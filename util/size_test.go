@@ -0,0 +1,39 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"1024", 1024},
+		{"10B", 10},
+		{"1KiB", 1 << 10},
+		{"512MiB", 512 << 20},
+		{"10GiB", 10 << 30},
+		{"1TiB", 1 << 40},
+		{"2KB", 2e3},
+		{"10GB", 10e9},
+		{"1.5MB", 1.5e6},
+		{" 3 MiB ", 3 << 20},
+	}
+	for _, test := range tests {
+		got, err := ParseSize(test.value)
+		if err != nil {
+			t.Errorf("ParseSize(%q): %v", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", test.value, got, test.want)
+		}
+	}
+	for _, value := range []string{"", "MiB", "-1", "10XB", "ten"} {
+		if _, err := ParseSize(value); err == nil {
+			t.Errorf("ParseSize(%q) succeeded, want error", value)
+		}
+	}
+}